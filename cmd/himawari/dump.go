@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"matbm.net/geonow/imagery/himawari"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// mjdFields are the header fields holding modified julian dates
+var mjdFields = map[string]bool{
+	"ObservationStartTime": true,
+	"ObservationEndTime":   true,
+	"FileCreationTime":     true,
+	"NavigationTime":       true,
+	"UpdateTime":           true,
+	"GSICSCorrectionStart": true,
+	"GSICSCorrectionEnd":   true,
+	"ObservationTime":      true,
+}
+
+// rfc3339Millis is RFC3339 with millisecond precision, float dates aren't more precise than that
+const rfc3339Millis = "2006-01-02T15:04:05.000Z07:00"
+
+// field is a named header value, a slice keeps the order of the blocks in the file
+type field struct {
+	Name  string
+	Value any
+}
+
+type fields []field
+
+// MarshalJSON writes the fields as an object in their order
+func (fs fields) MarshalJSON() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	for i, f := range fs {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(f.Name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(f.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// dump prints the headers of segment files as text or JSON
+func dump(args []string) {
+	flags := flag.NewFlagSet("dump", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the headers as JSON, one object per file")
+	_ = flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Println("Usage: himawari dump [-json] files...")
+		os.Exit(2)
+	}
+
+	if !dumpFiles(os.Stdout, os.Stderr, flags.Args(), *asJSON) {
+		os.Exit(1)
+	}
+}
+
+// dumpFiles writes the headers of segment files to w, it reports the files that can't be decoded to errw and
+// returns false if any of them failed
+func dumpFiles(w io.Writer, errw io.Writer, names []string, asJSON bool) bool {
+	ok := true
+	var headers []fields
+	for _, name := range names {
+		h, err := decodeHeader(name)
+		if err != nil {
+			fmt.Fprintf(errw, "Failed to decode %s: %s\n", name, err)
+			ok = false
+			continue
+		}
+		header := fields{{Name: "File", Value: name}}
+		header = append(header, headerFields(h)...)
+		if asJSON {
+			headers = append(headers, header)
+		} else {
+			printFields(w, header, "")
+			fmt.Fprintln(w)
+		}
+	}
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(headers); err != nil {
+			fmt.Fprintf(errw, "Failed to encode headers: %s\n", err)
+			return false
+		}
+	}
+	return ok
+}
+
+// decodeHeader decodes the header of a segment file, .bz2 files are decompressed
+func decodeHeader(name string) (*himawari.HMFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(name, ".bz2") {
+		r = bzip2.NewReader(f)
+	}
+	return himawari.DecodeFile(r)
+}
+
+// headerFields returns the header blocks of h, leaving out spare bytes and the calibration of the other band type
+func headerFields(h *himawari.HMFile) fields {
+	v := reflect.ValueOf(h).Elem()
+	var fs fields
+	for i := 0; i < v.NumField(); i++ {
+		t := v.Type().Field(i)
+		if !t.IsExported() || t.Name == "ImageData" {
+			continue
+		}
+		fs = append(fs, field{Name: t.Name, Value: value(t.Name, v.Field(i), h.IsInfrared())})
+	}
+	return fs
+}
+
+// value converts a header value into something printable, NUL padded strings are trimmed and dates are RFC3339
+func value(name string, v reflect.Value, infrared bool) any {
+	switch v.Kind() {
+	case reflect.Struct:
+		var fs fields
+		for i := 0; i < v.NumField(); i++ {
+			t := v.Type().Field(i)
+			if t.Name == "Spare" || (t.Name == "Infrared" && !infrared) || (t.Name == "Visible" && infrared) {
+				continue
+			}
+			fs = append(fs, field{Name: t.Name, Value: value(t.Name, v.Field(i), infrared)})
+		}
+		return fs
+	case reflect.Slice:
+		values := make([]any, v.Len())
+		for i := range values {
+			values[i] = value(name, v.Index(i), infrared)
+		}
+		return values
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return strings.TrimRight(string(b), "\x00")
+		}
+	case reflect.Interface:
+		// Byte order
+		return fmt.Sprint(v.Interface())
+	case reflect.Float64:
+		if mjdFields[name] && v.Float() != himawari.InvalidValue {
+			return himawari.MJDTime(v.Float()).Round(time.Millisecond).Format(rfc3339Millis)
+		}
+	}
+	return v.Interface()
+}
+
+// printFields prints fields one per line, nesting blocks and table entries
+func printFields(w io.Writer, fs fields, indent string) {
+	for _, f := range fs {
+		printValue(w, f.Name, f.Value, indent)
+	}
+}
+
+func printValue(w io.Writer, name string, v any, indent string) {
+	switch v := v.(type) {
+	case fields:
+		fmt.Fprintf(w, "%s%s\n", indent, name)
+		printFields(w, v, indent+"  ")
+	case []any:
+		fmt.Fprintf(w, "%s%s (%d)\n", indent, name, len(v))
+		for i, e := range v {
+			printValue(w, fmt.Sprintf("[%d]", i), e, indent+"  ")
+		}
+	default:
+		fmt.Fprintf(w, "%s%s: %v\n", indent, name, v)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"matbm.net/geonow/imagery/himawari"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dumpTestFile writes an infrared segment observed at 2023-10-31T12:00:00Z, MJD 60248.5, without a GSICS correction
+// period
+func dumpTestFile(t *testing.T) string {
+	f := himawari.NewSegment(13, 4, 2, 1, 1, time.Date(2023, 10, 31, 12, 0, 0, 0, time.UTC))
+	buf := &bytes.Buffer{}
+	if err := himawari.Encode(buf, f, make([]uint16, 8)); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(t.TempDir(), "HS_H09_20231031_1200_B13_FLDK_R20_S0101.DAT")
+	if err := os.WriteFile(name, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestDumpFiles(t *testing.T) {
+	name := dumpTestFile(t)
+	tests := []struct {
+		name    string
+		files   []string
+		json    bool
+		wantOK  bool
+		want    []string
+		wantErr string
+	}{
+		{
+			name:   "text",
+			files:  []string{name},
+			wantOK: true,
+			want: []string{
+				"File: " + name + "\n",
+				"BasicInfo\n",
+				"  Satellite: Himawari-9\n",
+				"  ObservationStartTime: 2023-10-31T12:00:00.000Z\n",
+				"  BandNumber: 13\n",
+				"  Infrared\n",
+				"  GSICSCorrectionStart: -1e+10\n",
+			},
+		},
+		{
+			name:   "json",
+			files:  []string{name},
+			json:   true,
+			wantOK: true,
+			want: []string{
+				`"File": "` + name + `"`,
+				`"ObservationStartTime": "2023-10-31T12:00:00.000Z"`,
+				`"GSICSCorrectionStart": -10000000000`,
+			},
+		},
+		{
+			name:    "missing file",
+			files:   []string{name + ".missing", name},
+			want:    []string{"File: " + name + "\n"},
+			wantErr: "Failed to decode " + name + ".missing",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+			if ok := dumpFiles(out, errOut, tt.files, tt.json); ok != tt.wantOK {
+				t.Errorf("expected ok %t but got %t", tt.wantOK, ok)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("expected %q in the output:\n%s", want, out)
+				}
+			}
+			if strings.Contains(out.String(), "Visible") {
+				t.Errorf("expected no visible calibration for an infrared band:\n%s", out)
+			}
+			if tt.json && !json.Valid(out.Bytes()) {
+				t.Errorf("expected valid JSON but got:\n%s", out)
+			}
+			if !strings.Contains(errOut.String(), tt.wantErr) || (tt.wantErr == "" && errOut.Len() > 0) {
+				t.Errorf("expected error output %q but got %q", tt.wantErr, errOut)
+			}
+		})
+	}
+}
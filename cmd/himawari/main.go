@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"io/fs"
+	"matbm.net/geonow/imagery/himawari"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Renders a himawari band from local HSD segments into a jpeg, or dumps the headers of segments
+// Usage: himawari [render] [flags] | himawari dump [-json] files...
+func main() {
+	args := os.Args[1:]
+	cmd := "render"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+	switch cmd {
+	case "render":
+		render(args)
+	case "dump":
+		dump(args)
+	default:
+		fmt.Printf("Unknown command %q, expected render or dump\n", cmd)
+		os.Exit(2)
+	}
+}
+
+// render decodes every segment of a band into a jpeg
+func render(args []string) {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	src := flags.String("src", "HS_H09_20231130_0030_B03_FLDK_R05", "prefix of the segment files to decode")
+	dir := flags.String("dir", "./sample-data", "directory containing the segment files")
+	downsample := flags.Int("downsample", 1, "reduce both axis by N")
+	box := flags.Bool("box", false, "average the downsampled pixels instead of skipping them, slower but smoother")
+	partial := flags.Bool("partial", false, "render the segments that are present, filling the missing ones in grey")
+	palette := flags.String("palette", "", "colours infrared bands by brightness temperature, one of "+strings.Join(himawari.PaletteNames(), ", "))
+	region := flags.String("region", "", "only render the south,west,north,east box in degrees, opening just the segments it covers, -partial doesn't apply")
+	out := flags.String("out", "", "output jpeg file, defaults to <src>_T<unix time>.jpg")
+	_ = flags.Parse(args)
+
+	opts := himawari.Options{Downsample: *downsample, Filter: himawari.FilterSkip, AllowMissing: *partial}
+	if *partial {
+		opts.NoData = color.RGBA{R: 48, G: 48, B: 48, A: 255}
+	}
+	if *box {
+		opts.Filter = himawari.FilterBox
+	}
+	if *palette != "" {
+		p, ok := himawari.Palettes[*palette]
+		if !ok {
+			fmt.Printf("Unknown palette %q\n", *palette)
+			os.Exit(2)
+		}
+		opts.Palette = &p
+	}
+	var res *himawari.Result
+	var err error
+	if *region != "" {
+		res, err = decodeRegion(*dir, *src, *region, opts)
+	} else {
+		var sections []io.ReadCloser
+		sections, err = himawari.OpenFiles(*dir, *src)
+		if err != nil {
+			fmt.Printf("Failed to open himawari sections: %s\n", err)
+			os.Exit(1)
+		}
+		res, err = himawari.Decode(context.Background(), sections, opts)
+	}
+	if err != nil {
+		fmt.Printf("Failed to decode file: %s\n", err)
+		os.Exit(1)
+	}
+	if len(res.Missing) > 0 {
+		fmt.Printf("Missing segments %v\n", res.Missing)
+	}
+
+	fileName := *out
+	if fileName == "" {
+		fileName = *src + fmt.Sprintf("_T%d", time.Now().Unix()) + ".jpg"
+	}
+	fimg, err := os.Create(fileName)
+	if err != nil {
+		fmt.Printf("Failed to create %s: %s\n", fileName, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Saving to %s...\n", fileName)
+	err = jpeg.Encode(fimg, res.Image, &jpeg.Options{Quality: 90})
+	if err != nil {
+		fmt.Printf("Failed to encode %s: %s\n", fileName, err)
+		os.Exit(1)
+	}
+	if err = fimg.Close(); err != nil {
+		fmt.Printf("Failed to close %s: %s\n", fileName, err)
+		os.Exit(1)
+	}
+}
+
+// decodeRegion decodes the box of the band of the src segments, reading only the segments covering it
+func decodeRegion(dir, src, box string, opts himawari.Options) (*himawari.Result, error) {
+	var b himawari.LatLonBox
+	if _, err := fmt.Sscanf(box, "%f,%f,%f,%f", &b.South, &b.West, &b.North, &b.East); err != nil {
+		return nil, fmt.Errorf("invalid region %q, expected south,west,north,east: %w", box, err)
+	}
+	band, err := segmentBand(src)
+	if err != nil {
+		return nil, err
+	}
+	region, ok := b.Region(band)
+	if !ok {
+		return nil, fmt.Errorf("region %q isn't visible from the satellite", box)
+	}
+	open := func(_ context.Context, segment int) (io.ReadCloser, error) {
+		name := fmt.Sprintf("%s/%s_S%02d%02d.DAT", dir, src, segment, himawari.FullDiskSegments)
+		f, err := os.Open(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			if f, err = os.Open(name + ".bz2"); err == nil {
+				return himawari.NewBzip2Reader(f), nil
+			}
+		}
+		return f, err
+	}
+	return himawari.DecodeRegion(context.Background(), band, open, region, opts)
+}
+
+// segmentBand returns the band of a segment file prefix, e.g. 3 for HS_H09_20231130_0030_B03_FLDK_R05
+func segmentBand(src string) (int, error) {
+	for _, field := range strings.Split(src, "_") {
+		if len(field) == 3 && field[0] == 'B' {
+			if band, err := strconv.Atoi(field[1:]); err == nil {
+				return band, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("no band in %q", src)
+}
@@ -8,6 +8,10 @@ type AppConfig struct {
 	UpdateInterval    time.Duration
 	MaxWidth          int
 	MaxHeight         int
+	HimawariBaseURL   string
+	// RefreshIntervals are the sources refreshed in the background and how often, other sources and palettes are
+	// refreshed by requests once older than UpdateInterval
+	RefreshIntervals map[string]time.Duration
 }
 
 var DefaultConfig = AppConfig{
@@ -16,4 +20,13 @@ var DefaultConfig = AppConfig{
 	UpdateInterval:    time.Minute * 16,
 	MaxWidth:          10000,
 	MaxHeight:         10000,
+	HimawariBaseURL:   "https://noaa-himawari9.s3.amazonaws.com",
+	// Full disk observations are every 10 minutes
+	RefreshIntervals: map[string]time.Duration{
+		"goes":               time.Minute * 10,
+		"himawari":           time.Minute * 10,
+		"himawari-ir":        time.Minute * 10,
+		"himawari-truecolor": time.Minute * 10,
+		"himawari-daynight":  time.Minute * 10,
+	},
 }
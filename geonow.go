@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	_ "embed"
 	"github.com/davidbyttow/govips/v2/vips"
 	"log"
@@ -17,14 +18,16 @@ func main() {
 	// Start rate limit routine
 	go ratelimit.CleanRateLimits()
 
-	// Configure the handlers
-	http.HandleFunc("/", handlers.ImageHandler)
-	http.HandleFunc("/r", handlers.RedirectorHandler)
+	// Refresh the sources in the background
+	if err := handlers.DefaultScheduler.RegisterSources(); err != nil {
+		log.Fatalf("Failed to register sources: %s", err)
+	}
+	go handlers.DefaultScheduler.Run(context.Background())
 
 	// Start the webserver
 	serveAddr := ":8080"
 	log.Printf("Server is running at %s", serveAddr)
-	err := http.ListenAndServe(serveAddr, nil)
+	err := http.ListenAndServe(serveAddr, handlers.NewRouter())
 	if err != nil {
 		log.Printf("Failed to serve at %s", serveAddr)
 	}
@@ -4,11 +4,12 @@ go 1.21
 
 require (
 	github.com/davidbyttow/govips/v2 v2.15.0
+	github.com/google/go-cmp v0.6.0
+	golang.org/x/sync v0.7.0
 	golang.org/x/time v0.4.0
 )
 
 require (
-	github.com/google/go-cmp v0.6.0 // indirect
 	golang.org/x/image v0.14.0 // indirect
 	golang.org/x/net v0.23.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
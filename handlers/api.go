@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"matbm.net/geonow/imagery"
+	"matbm.net/geonow/imagery/himawari"
+	"net/http"
+)
+
+// HealthHandler reports that the server is up
+func HealthHandler(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// SourcesHandler lists the image sources
+func SourcesHandler(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string][]string{"sources": imagery.SourceNames()})
+}
+
+// PalettesHandler lists the palettes of infrared himawari sources
+func PalettesHandler(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string][]string{"palettes": himawari.PaletteNames()})
+}
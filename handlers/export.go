@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/davidbyttow/govips/v2/vips"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Chroma is the chroma subsampling of JPEG exports
+type Chroma string
+
+const (
+	// ChromaAuto lets libvips subsample depending on the quality
+	ChromaAuto Chroma = "auto"
+	// Chroma420 halves the colour resolution in both axis, smaller files
+	Chroma420 Chroma = "420"
+	// Chroma444 keeps the full colour resolution, sharper coastlines and text
+	Chroma444 Chroma = "444"
+)
+
+const (
+	minQuality = 1
+	maxQuality = 100
+)
+
+// ExportOptions are the compression parameters of a request, zero values keep the defaults of the format
+type ExportOptions struct {
+	// Quality from 1 to 100 of lossy formats, 0 is the default of the format
+	Quality int
+	// Progressive interlaces JPEG and PNG exports, nil is the default of the format
+	Progressive *bool
+	// StripMetadata removes EXIF, XMP and ICC metadata
+	StripMetadata bool
+	// Chroma subsampling of JPEG exports, empty is ChromaAuto
+	Chroma Chroma
+}
+
+// parseExportOptions returns the export options of the quality, progressive, strip and chroma query parameters
+func parseExportOptions(query url.Values) (ExportOptions, error) {
+	var o ExportOptions
+	if v := query.Get("quality"); v != "" {
+		quality, err := strconv.Atoi(v)
+		if err != nil || quality < minQuality || quality > maxQuality {
+			return ExportOptions{}, fmt.Errorf("quality must be between %d and %d", minQuality, maxQuality)
+		}
+		o.Quality = quality
+	}
+	if v := query.Get("progressive"); v != "" {
+		progressive, err := strconv.ParseBool(v)
+		if err != nil {
+			return ExportOptions{}, fmt.Errorf("progressive must be true or false")
+		}
+		o.Progressive = &progressive
+	}
+	if v := query.Get("strip"); v != "" {
+		strip, err := strconv.ParseBool(v)
+		if err != nil {
+			return ExportOptions{}, fmt.Errorf("strip must be true or false")
+		}
+		o.StripMetadata = strip
+	}
+	switch c := Chroma(query.Get("chroma")); c {
+	case "", ChromaAuto:
+	case Chroma420, Chroma444:
+		o.Chroma = c
+	default:
+		return ExportOptions{}, fmt.Errorf("chroma must be %s, %s or %s", ChromaAuto, Chroma420, Chroma444)
+	}
+	return o, nil
+}
+
+// key returns a cache file name suffix unique to the options, empty for the defaults
+func (o ExportOptions) key() string {
+	var parts []string
+	if o.Quality != 0 {
+		parts = append(parts, "q"+strconv.Itoa(o.Quality))
+	}
+	if o.Progressive != nil {
+		if *o.Progressive {
+			parts = append(parts, "progressive")
+		} else {
+			parts = append(parts, "baseline")
+		}
+	}
+	if o.StripMetadata {
+		parts = append(parts, "strip")
+	}
+	if o.Chroma != "" && o.Chroma != ChromaAuto {
+		parts = append(parts, "c"+string(o.Chroma))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "-" + strings.Join(parts, "-")
+}
+
+// export encodes an image in a format, options that don't apply to the format are ignored
+func export(img *vips.ImageRef, f Format, o ExportOptions) ([]byte, *vips.ImageMetadata, error) {
+	switch f {
+	case FormatPNG:
+		p := vips.NewPngExportParams()
+		p.StripMetadata = o.StripMetadata
+		if o.Progressive != nil {
+			p.Interlace = *o.Progressive
+		}
+		return img.ExportPng(p)
+	case FormatWebP:
+		p := vips.NewWebpExportParams()
+		p.StripMetadata = o.StripMetadata
+		if o.Quality != 0 {
+			p.Quality = o.Quality
+		}
+		return img.ExportWebp(p)
+	case FormatAVIF:
+		p := vips.NewAvifExportParams()
+		p.StripMetadata = o.StripMetadata
+		if o.Quality != 0 {
+			p.Quality = o.Quality
+		}
+		return img.ExportAvif(p)
+	}
+	p := vips.NewJpegExportParams()
+	p.StripMetadata = o.StripMetadata
+	if o.Quality != 0 {
+		p.Quality = o.Quality
+	}
+	if o.Progressive != nil {
+		p.Interlace = *o.Progressive
+	}
+	switch o.Chroma {
+	case Chroma420:
+		p.SubsampleMode = vips.VipsForeignSubsampleOn
+	case Chroma444:
+		p.SubsampleMode = vips.VipsForeignSubsampleOff
+	}
+	return img.ExportJpeg(p)
+}
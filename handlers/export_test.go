@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseExportOptions(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		query   string
+		want    ExportOptions
+		wantKey string
+		wantErr bool
+	}{
+		{query: "", want: ExportOptions{}, wantKey: ""},
+		{query: "quality=60", want: ExportOptions{Quality: 60}, wantKey: "-q60"},
+		{query: "quality=1", want: ExportOptions{Quality: 1}, wantKey: "-q1"},
+		{query: "quality=100", want: ExportOptions{Quality: 100}, wantKey: "-q100"},
+		{query: "progressive=true", want: ExportOptions{Progressive: &yes}, wantKey: "-progressive"},
+		{query: "progressive=0", want: ExportOptions{Progressive: &no}, wantKey: "-baseline"},
+		{query: "strip=1", want: ExportOptions{StripMetadata: true}, wantKey: "-strip"},
+		{query: "strip=false", want: ExportOptions{}, wantKey: ""},
+		{query: "chroma=auto", want: ExportOptions{}, wantKey: ""},
+		{query: "chroma=444", want: ExportOptions{Chroma: Chroma444}, wantKey: "-c444"},
+		{query: "quality=30&progressive=false&strip=true&chroma=420",
+			want:    ExportOptions{Quality: 30, Progressive: &no, StripMetadata: true, Chroma: Chroma420},
+			wantKey: "-q30-baseline-strip-c420"},
+		{query: "quality=0", wantErr: true},
+		{query: "quality=101", wantErr: true},
+		{query: "quality=high", wantErr: true},
+		{query: "progressive=maybe", wantErr: true},
+		{query: "strip=yes", wantErr: true},
+		{query: "chroma=422", wantErr: true},
+	}
+	for _, tt := range tests {
+		query, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := parseExportOptions(query)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("expected error %t for %q but got %v", tt.wantErr, tt.query, err)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if got.Quality != tt.want.Quality || got.StripMetadata != tt.want.StripMetadata || got.Chroma != tt.want.Chroma ||
+			(got.Progressive == nil) != (tt.want.Progressive == nil) ||
+			(got.Progressive != nil && *got.Progressive != *tt.want.Progressive) {
+			t.Errorf("expected %+v for %q but got %+v", tt.want, tt.query, got)
+		}
+		if key := got.key(); key != tt.wantKey {
+			t.Errorf("expected key %q for %q but got %q", tt.wantKey, tt.query, key)
+		}
+	}
+}
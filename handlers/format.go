@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// Format is an output image format, named by its file extension
+type Format string
+
+const (
+	FormatJPEG Format = "jpg"
+	FormatPNG  Format = "png"
+	FormatWebP Format = "webp"
+	FormatAVIF Format = "avif"
+)
+
+// negotiableFormats are the formats picked from an Accept header, by preference when equally accepted
+var negotiableFormats = []Format{FormatAVIF, FormatWebP, FormatJPEG, FormatPNG}
+
+// ContentType returns the media type of the format
+func (f Format) ContentType() string {
+	switch f {
+	case FormatPNG:
+		return "image/png"
+	case FormatWebP:
+		return "image/webp"
+	case FormatAVIF:
+		return "image/avif"
+	}
+	return "image/jpeg"
+}
+
+// parseFormat returns the format of a file extension, without the dot
+func parseFormat(ext string) (Format, error) {
+	switch f := Format(strings.ToLower(ext)); f {
+	case "jpeg":
+		return FormatJPEG, nil
+	case FormatJPEG, FormatPNG, FormatWebP, FormatAVIF:
+		return f, nil
+	}
+	return "", fmt.Errorf("invalid format %q", ext)
+}
+
+// negotiateFormat returns the most accepted format of an Accept header, JPEG when none is explicitly accepted
+// Wildcards don't pick a format, every client understands JPEG but not every one that sends image/* knows AVIF
+func negotiateFormat(accept string) Format {
+	best, bestQ := FormatJPEG, 0.
+	for _, r := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(r))
+		if err != nil {
+			continue
+		}
+		q := 1.
+		if v, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+		}
+		for _, f := range negotiableFormats {
+			if f.ContentType() != mediaType || q < bestQ || q == 0 {
+				continue
+			}
+			// Equally accepted formats keep the preferred one
+			if q > bestQ || preference(f) < preference(best) {
+				best, bestQ = f, q
+			}
+		}
+	}
+	return best
+}
+
+// preference returns the index of a format in negotiableFormats, lower is preferred
+func preference(f Format) int {
+	for i, n := range negotiableFormats {
+		if n == f {
+			return i
+		}
+	}
+	return len(negotiableFormats)
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"testing"
+)
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		ext     string
+		want    Format
+		wantErr bool
+	}{
+		{ext: "jpg", want: FormatJPEG},
+		{ext: "jpeg", want: FormatJPEG},
+		{ext: "JPG", want: FormatJPEG},
+		{ext: "png", want: FormatPNG},
+		{ext: "webp", want: FormatWebP},
+		{ext: "avif", want: FormatAVIF},
+		{ext: "gif", wantErr: true},
+		{ext: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseFormat(tt.ext)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("expected error %t for %q but got %v", tt.wantErr, tt.ext, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("expected %q for %q but got %q", tt.want, tt.ext, got)
+		}
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   Format
+	}{
+		{accept: "", want: FormatJPEG},
+		{accept: "*/*", want: FormatJPEG},
+		{accept: "image/*", want: FormatJPEG},
+		{accept: "image/png", want: FormatPNG},
+		{accept: "image/webp,*/*", want: FormatWebP},
+		// Chrome
+		{accept: "image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8", want: FormatAVIF},
+		// Firefox
+		{accept: "image/avif,image/webp,*/*", want: FormatAVIF},
+		{accept: "image/webp;q=0.9, image/avif;q=0.5", want: FormatWebP},
+		{accept: "image/avif;q=0, image/webp", want: FormatWebP},
+		{accept: "image/png, image/jpeg", want: FormatJPEG},
+		{accept: "IMAGE/WEBP", want: FormatWebP},
+		{accept: "image/webp;q=x, image/png", want: FormatPNG},
+		{accept: "text/html", want: FormatJPEG},
+	}
+	for _, tt := range tests {
+		if got := negotiateFormat(tt.accept); got != tt.want {
+			t.Errorf("expected %q for %q but got %q", tt.want, tt.accept, got)
+		}
+	}
+}
+
+func TestFormatContentType(t *testing.T) {
+	for f, want := range map[Format]string{
+		FormatJPEG: "image/jpeg",
+		FormatPNG:  "image/png",
+		FormatWebP: "image/webp",
+		FormatAVIF: "image/avif",
+	} {
+		if got := f.ContentType(); got != want {
+			t.Errorf("expected %s for %s but got %s", want, f, got)
+		}
+	}
+}
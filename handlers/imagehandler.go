@@ -2,12 +2,14 @@ package handlers
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
-	"github.com/davidbyttow/govips/v2/vips"
+	"io"
 	"log"
 	"matbm.net/geonow/config"
 	"matbm.net/geonow/imagery"
+	"matbm.net/geonow/imagery/himawari"
 	"matbm.net/geonow/ratelimit"
 	"net/http"
 	"os"
@@ -16,113 +18,151 @@ import (
 	"time"
 )
 
-func ImageHandler(w http.ResponseWriter, r *http.Request) {
+// ImageHandler serves the latest image of a source resized to the {size} of the request, WxH[.ext]
+func ImageHandler(w http.ResponseWriter, r *http.Request, vars map[string]string) {
 	cli, err := ratelimit.GetClient(r)
 	if err != nil {
 		log.Printf("Failed to get rate limit client: %s", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "internal server error")
+		return
 	}
 
-	// Parse client request
-	parts := strings.Split(r.URL.Path, "/")
-	if len(parts) != 3 {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	// Get the source the client wants
+	srcName := vars["source"]
+	palette := r.URL.Query().Get("palette")
+	src, ok := requestSource(w, srcName, palette)
+	if !ok {
 		return
 	}
+	// Renders with a palette are cached apart from the default ones, naming the default palette is the default render
+	if palette == himawari.PaletteGrayscaleInverted.Name {
+		palette = ""
+	}
+	cacheName := srcName
+	if palette != "" {
+		cacheName += "-" + palette
+	}
 
-	// Get the source the client wants
-	srcName := parts[1]
-	src, err := imagery.GetSource(srcName, &imagery.Parameters{MaxWidth: config.DefaultConfig.MaxWidth})
+	// Parse the dimensions and format the client wants, without an extension the format is negotiated
+	dimensions, ext, hasExt := strings.Cut(vars["size"], ".")
+	width, height, err := parseDimensions(dimensions)
 	if err != nil {
-		http.Error(w, "Invalid source", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "invalid dimensions")
 		return
 	}
-
-	// Check if the client wants the max resolution and redirect to it
-	if parts[2] == "max" {
-		http.Redirect(w, r, src.SourceURL(), http.StatusFound)
+	format := negotiateFormat(r.Header.Get("Accept"))
+	if hasExt {
+		format, err = parseFormat(ext)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid format")
+			return
+		}
+	}
+	mode, err := parseFitMode(r.URL.Query().Get("fit"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid fit mode")
 		return
 	}
-
-	// Parse the dimensions the client wants
-	dimensions := parts[2]
-	width, height, err := parseDimensions(dimensions)
+	exportOpts, err := parseExportOptions(r.URL.Query())
 	if err != nil {
-		http.Error(w, "Invalid dimensions", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "invalid export options: "+err.Error())
 		return
 	}
-	log.Printf("Client request for %s to %dx%d", srcName, width, height)
+	t := thumb{width: width, height: height, mode: mode, format: format, opts: exportOpts}
+	log.Printf("Client request for %s to %dx%d (%s) as %s", cacheName, width, height, mode, format)
 
-	// Download latest image if necessary
-	// TODO: some source's won't be jpg
-	latestImage := imagePath(srcName, "latest.jpg")
-	lastRefresh, err := modTime(latestImage)
+	// Scheduled sources are refreshed in the background, requests only download them on a cold start
+	lastRefresh, err := modTime(imagePath(cacheName, "latest-clean.jpg"))
 	if err != nil {
-		http.Error(w, "Failed to get last refresh", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "failed to get last refresh")
 		return
 	}
-	needsRefresh := isDownloadRequired(lastRefresh)
-	needsResize := isResizeRequired(lastRefresh, srcName, dimensions) || needsRefresh || config.DefaultConfig.DisableThumbCache
+	scheduled := DefaultScheduler.Scheduled(cacheName)
+	needsRefresh := lastRefresh.IsZero() || (!scheduled && isDownloadRequired(lastRefresh))
+	needsResize := isResizeRequired(lastRefresh, cacheName, t.file()) || needsRefresh || config.DefaultConfig.DisableThumbCache
 
 	// Expensive operation, rate limit it
 	if (needsRefresh || needsResize) && !cli.AllowsExpensive() {
-		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		writeError(w, http.StatusTooManyRequests, "too many requests")
 		return
 		// Cheap operation (img serve) shouldn't be too strict
 	} else if !cli.AllowsCheap() {
-		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		writeError(w, http.StatusTooManyRequests, "too many requests")
 		return
 	}
 
 	if needsRefresh {
-		log.Printf("Downloading latest %s image", srcName)
-		_ = os.Mkdir(config.DefaultConfig.CacheDir, 0755)
-		err = downloadLatestImage(src, latestImage)
-		if err != nil {
-			log.Printf("Error downloading %s image: %v", srcName, err)
-			http.Error(w, "Failed to download latest image", http.StatusInternalServerError)
-			return
-		}
-		srcImg, err := os.Open(imagePath(srcName, "latest.jpg"))
-		if err != nil {
-			log.Printf("Failed to open latest image: %s", err)
-			http.Error(w, "Failed to post process image", http.StatusInternalServerError)
-			return
-		}
-		dstImg, err := os.Create(imagePath(srcName, "latest-clean.jpg"))
-		if err != nil {
-			log.Printf("Failed to create dst image: %s", err)
-			http.Error(w, "Failed to post process image", http.StatusInternalServerError)
-			return
-		}
-		err = src.PostProcess(srcImg, dstImg)
+		err = refreshSource(r.Context(), src, cacheName)
 		if err != nil {
-			log.Printf("Error post processing image: %v", err)
-			http.Error(w, "Failed to post process image", http.StatusInternalServerError)
+			log.Printf("Error refreshing %s image: %v", cacheName, err)
+			writeError(w, http.StatusInternalServerError, "failed to refresh latest image")
 			return
 		}
+		lastRefresh, _ = modTime(imagePath(cacheName, "latest-clean.jpg"))
 	}
 
 	// Resize or use cached image
-	cachedImagePath := imagePath(srcName, dimensions+".jpg")
+	cachedImagePath := imagePath(cacheName, t.file())
 	stat, err := os.Stat(cachedImagePath)
 	if err != nil && !errors.Is(err, os.ErrNotExist) {
-		http.Error(w, "Failed to stat cached image", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "failed to stat cached image")
 		return
 	}
-	needsResize = config.DefaultConfig.DisableThumbCache || needsRefresh || errors.Is(err, os.ErrNotExist) || stat.ModTime().Before(lastRefresh)
+	needsResize = config.DefaultConfig.DisableThumbCache || errors.Is(err, os.ErrNotExist) || stat.ModTime().Before(lastRefresh)
 	if needsResize {
-		err := resizeImage(imagePath(srcName, "latest-clean.jpg"), width, height, cachedImagePath)
+		err := renderThumb(cacheName, t)
 		if err != nil {
 			log.Printf("Error processing image %v", err)
-			http.Error(w, "Error resizing image", http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, "error resizing image")
 			return
 		}
 	}
 
+	// Only thumbs that are served count towards the ones the scheduler renders
+	DefaultScheduler.Requested(cacheName, t)
+	w.Header().Set("Content-Type", format.ContentType())
+	if !hasExt {
+		// The same URL serves other formats to other clients
+		w.Header().Add("Vary", "Accept")
+	}
+	if acquired, err := readAcquisitionTime(imagePath(cacheName, "latest.time")); err == nil {
+		w.Header().Set("X-Acquisition-Time", acquired.Format(time.RFC3339))
+	}
 	http.ServeFile(w, r, cachedImagePath)
 }
 
+// MaxHandler redirects to the max resolution of a source
+func MaxHandler(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+	src, ok := requestSource(w, vars["source"], r.URL.Query().Get("palette"))
+	if !ok {
+		return
+	}
+	http.Redirect(w, r, src.SourceURL(), http.StatusFound)
+}
+
+// requestSource returns the named source, unknown sources are 404 and unknown palettes 400
+func requestSource(w http.ResponseWriter, name string, palette string) (imagery.ImageSource, bool) {
+	src, err := getSource(name, palette)
+	if errors.Is(err, imagery.ErrInvalidSource) {
+		writeError(w, http.StatusNotFound, "unknown source")
+		return nil, false
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	return src, true
+}
+
+// getSource returns the named source configured by config.DefaultConfig
+func getSource(name string, palette string) (imagery.ImageSource, error) {
+	return imagery.GetSource(name, &imagery.Parameters{
+		MaxWidth:        config.DefaultConfig.MaxWidth,
+		HimawariBaseURL: config.DefaultConfig.HimawariBaseURL,
+		Palette:         palette,
+	})
+}
+
 // imagePath returns the cached image path based in a source
 func imagePath(src string, name string) string {
 	return config.DefaultConfig.CacheDir + "/" + src + "-" + name
@@ -136,37 +176,62 @@ func modTime(filePath string) (time.Time, error) {
 	return stat.ModTime(), nil
 }
 
-// TODO: some sources may need different update intervals
+// isDownloadRequired returns whether the image of a source that isn't scheduled is stale, see Scheduler
 func isDownloadRequired(t time.Time) bool {
 	return t.Before(time.Now().Add(-config.DefaultConfig.UpdateInterval))
 }
 
-func isResizeRequired(lastRefresh time.Time, src string, dimensions string) bool {
-	cachedImagePath := imagePath(src, dimensions+".jpg")
+func isResizeRequired(lastRefresh time.Time, src string, thumbFile string) bool {
+	cachedImagePath := imagePath(src, thumbFile)
 	stat, err := os.Stat(cachedImagePath)
-	return os.IsNotExist(err) || stat.ModTime().Before(lastRefresh) || os.IsNotExist(err)
+	if err != nil {
+		return true
+	}
+	return stat.ModTime().Before(lastRefresh)
 }
 
-func downloadLatestImage(src imagery.ImageSource, dst string) error {
+// downloadLatestImage downloads the latest image to dst, sources that know when it was observed write the time to
+// timePath, otherwise it is removed
+func downloadLatestImage(ctx context.Context, src imagery.ImageSource, dst string, timePath string) error {
 	// Download the latest img
-	r, err := src.DownloadImage()
+	var r *bufio.Reader
+	var acquired time.Time
+	var err error
+	if timed, ok := src.(imagery.TimedSource); ok {
+		r, acquired, err = timed.DownloadTimedImage(ctx)
+	} else {
+		r, err = src.DownloadImage()
+	}
 	if err != nil {
 		return err
 	}
 
-	f, err := os.Create(dst)
+	err = writeFileAtomic(dst, func(w io.Writer) error {
+		b, err := r.WriteTo(w)
+		if err != nil {
+			return err
+		}
+		log.Printf("%d bytes written to %s", b, dst)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	b, err := r.WriteTo(bufio.NewWriter(f))
-	if err != nil {
-		return err
+	if acquired.IsZero() {
+		_ = os.Remove(timePath)
+		return nil
 	}
-	log.Printf("%d bytes written to %s", b, dst)
+	return os.WriteFile(timePath, []byte(acquired.UTC().Format(time.RFC3339Nano)), 0660)
+}
 
-	return nil
+// readAcquisitionTime returns the time written by downloadLatestImage
+func readAcquisitionTime(timePath string) (time.Time, error) {
+	b, err := os.ReadFile(timePath)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, string(b))
 }
 
 func parseDimensions(dimensions string) (int, int, error) {
@@ -191,49 +256,3 @@ func parseDimensions(dimensions string) (int, int, error) {
 
 	return width, height, nil
 }
-
-func resizeImage(srcPath string, width, height int, savePath string) error {
-	// convert input.jpg -resize 800x600 -background black -gravity center -extent 800x600 output.jpg
-	wh := fmt.Sprintf("%dx%d", width, height)
-	dst := fmt.Sprintf("%s/%s.jpg", config.DefaultConfig.CacheDir, wh)
-	img, err := vips.NewImageFromFile(srcPath)
-	if err != nil {
-		return err
-	}
-	// Gravity center resize
-	var dim, left, top int
-	if width > height {
-		dim = height
-		left = (width - height) / 2
-	} else {
-		dim = width
-		top = (height - width) / 2
-	}
-	_ = dim
-	// FIXME: this expects a 1:1 image, if not, causes warping
-	err = img.ThumbnailWithSize(dim, dim, vips.InterestingAttention, vips.SizeForce)
-	if err != nil {
-		return err
-	}
-	err = img.EmbedBackground(left, top, width, height, &vips.Color{
-		R: 0,
-		G: 0,
-		B: 0,
-	})
-	if err != nil {
-		return err
-	}
-	// TODO: maybe it isn't a good idea to write to a buffer? (memory consumption)
-	jpeg, metadata, err := img.ExportJpeg(nil)
-	if err != nil {
-		return err
-	}
-	err = os.WriteFile(savePath, jpeg, 0660)
-	if err != nil {
-		return err
-	}
-
-	log.Printf("Resize: %s -> %s, %dx%d", srcPath, dst, metadata.Width, metadata.Height)
-
-	return nil
-}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"matbm.net/geonow/config"
+	"matbm.net/geonow/imagery"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// thumb is a resized rendition of the latest image of a source
+type thumb struct {
+	width, height int
+	mode          FitMode
+	format        Format
+	opts          ExportOptions
+}
+
+// file returns the cache file name of the thumb, contain is the default mode and isn't part of the name
+func (t thumb) file() string {
+	name := fmt.Sprintf("%dx%d", t.width, t.height)
+	if t.mode != FitContain {
+		name += "-" + string(t.mode)
+	}
+	return name + t.opts.key() + "." + string(t.format)
+}
+
+// refreshLocks serializes the refreshes of every cached source, by cache name
+var refreshLocks sync.Map
+
+// refreshSource downloads the latest image of a source and post processes it into the cache
+// A refresh waiting for a running one of the same source returns as soon as that one succeeds, the download stops
+// when ctx is done
+func refreshSource(ctx context.Context, src imagery.ImageSource, cacheName string) error {
+	l, _ := refreshLocks.LoadOrStore(cacheName, &sync.Mutex{})
+	mu := l.(*sync.Mutex)
+	waiting := time.Now()
+	mu.Lock()
+	defer mu.Unlock()
+	if lastRefresh, _ := modTime(imagePath(cacheName, "latest-clean.jpg")); lastRefresh.After(waiting) {
+		return nil
+	}
+
+	log.Printf("Downloading latest %s image", cacheName)
+	_ = os.Mkdir(config.DefaultConfig.CacheDir, 0755)
+	latestImage := imagePath(cacheName, "latest.jpg")
+	err := downloadLatestImage(ctx, src, latestImage, imagePath(cacheName, "latest.time"))
+	if err != nil {
+		return fmt.Errorf("failed to download latest image: %w", err)
+	}
+	srcImg, err := os.Open(latestImage)
+	if err != nil {
+		return fmt.Errorf("failed to open latest image: %w", err)
+	}
+	defer srcImg.Close()
+	// Requests keep reading the previous image until the new one is complete
+	err = writeFileAtomic(imagePath(cacheName, "latest-clean.jpg"), func(w io.Writer) error {
+		return src.PostProcess(srcImg, w)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to post process image: %w", err)
+	}
+	return nil
+}
+
+// renderThumb resizes the latest image of a source into the cache
+func renderThumb(cacheName string, t thumb) error {
+	return resizeImage(imagePath(cacheName, "latest-clean.jpg"), t.width, t.height, t.mode, t.format, t.opts, imagePath(cacheName, t.file()))
+}
+
+// writeFileAtomic writes a file through a temporary one renamed when complete, so it is never read half written
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	err = write(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(f.Name(), 0660); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
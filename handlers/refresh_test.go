@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"matbm.net/geonow/config"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testCacheDir points the cache to a temporary directory for the duration of a test
+func testCacheDir(t *testing.T) string {
+	dir := t.TempDir()
+	previous := config.DefaultConfig.CacheDir
+	config.DefaultConfig.CacheDir = dir
+	t.Cleanup(func() {
+		config.DefaultConfig.CacheDir = previous
+	})
+	return dir
+}
+
+// fakeSource downloads a fixed image, post processing prefixes it with "clean "
+type fakeSource struct {
+	image     string
+	delay     time.Duration
+	downloads atomic.Int32
+}
+
+func (f *fakeSource) DownloadImage() (*bufio.Reader, error) {
+	f.downloads.Add(1)
+	time.Sleep(f.delay)
+	return bufio.NewReader(strings.NewReader(f.image)), nil
+}
+
+func (f *fakeSource) PostProcess(src io.Reader, dst io.Writer) error {
+	if _, err := io.WriteString(dst, "clean "); err != nil {
+		return err
+	}
+	_, err := io.Copy(dst, src)
+	return err
+}
+
+func (f *fakeSource) SourceURL() string {
+	return ""
+}
+
+func TestRefreshSource(t *testing.T) {
+	dir := testCacheDir(t)
+	src := &fakeSource{image: "image"}
+	if err := refreshSource(context.Background(), src, "fake"); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"latest.jpg": "image", "latest-clean.jpg": "clean image"} {
+		b, err := os.ReadFile(imagePath("fake", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Errorf("expected %s to be %q but got %q", name, want, b)
+		}
+	}
+
+	// Temporary files are renamed or removed
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".tmp") {
+			t.Errorf("expected no temporary files but got %s", e.Name())
+		}
+	}
+}
+
+func TestRefreshSourceConcurrent(t *testing.T) {
+	testCacheDir(t)
+	src := &fakeSource{image: "image", delay: 50 * time.Millisecond}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := refreshSource(context.Background(), src, "concurrent"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := src.downloads.Load(); n != 1 {
+		t.Errorf("expected concurrent refreshes to download once but got %d", n)
+	}
+
+	// Later refreshes download again
+	if err := refreshSource(context.Background(), src, "concurrent"); err != nil {
+		t.Fatal(err)
+	}
+	if n := src.downloads.Load(); n != 2 {
+		t.Errorf("expected a second download but got %d", n)
+	}
+}
+
+func TestThumbFile(t *testing.T) {
+	yes := true
+	tests := []struct {
+		thumb thumb
+		want  string
+	}{
+		{thumb: thumb{width: 800, height: 600, mode: FitContain, format: FormatJPEG}, want: "800x600.jpg"},
+		{thumb: thumb{width: 800, height: 600, mode: FitCover, format: FormatWebP}, want: "800x600-cover.webp"},
+		{thumb: thumb{width: 1920, height: 1080, mode: FitStretch, format: FormatAVIF, opts: ExportOptions{Quality: 40, Progressive: &yes}},
+			want: "1920x1080-stretch-q40-progressive.avif"},
+	}
+	for _, tt := range tests {
+		if got := tt.thumb.file(); got != tt.want {
+			t.Errorf("expected %s but got %s", tt.want, got)
+		}
+	}
+}
+
+func TestIsResizeRequired(t *testing.T) {
+	dir := testCacheDir(t)
+	lastRefresh := time.Now()
+	if err := os.WriteFile(imagePath("goes", "old.jpg"), nil, 0660); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(imagePath("goes", "old.jpg"), lastRefresh, lastRefresh.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(imagePath("goes", "new.jpg"), nil, 0660); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(imagePath("goes", "new.jpg"), lastRefresh, lastRefresh.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	// A file where the cache expects a directory fails to stat
+	if err := os.WriteFile(dir+"/file", nil, 0660); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		src   string
+		thumb string
+		want  bool
+	}{
+		{src: "goes", thumb: "missing.jpg", want: true},
+		{src: "goes", thumb: "old.jpg", want: true},
+		{src: "goes", thumb: "new.jpg", want: false},
+		{src: "file/goes", thumb: "new.jpg", want: true},
+	}
+	for _, tt := range tests {
+		if got := isResizeRequired(lastRefresh, tt.src, tt.thumb); got != tt.want {
+			t.Errorf("expected %t for %s %s but got %t", tt.want, tt.src, tt.thumb, got)
+		}
+	}
+}
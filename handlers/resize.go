@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/davidbyttow/govips/v2/vips"
+	"io"
+	"log"
+	"math"
+)
+
+// FitMode is how a source image is fitted into the requested dimensions
+type FitMode string
+
+const (
+	// FitContain scales the whole source inside the dimensions, letterboxing the rest in black
+	FitContain FitMode = "contain"
+	// FitCover scales the source to fill the dimensions, cropping what overflows around the center
+	FitCover FitMode = "cover"
+	// FitStretch scales each axis of the source to the dimensions, warping it when the aspect ratios differ
+	FitStretch FitMode = "stretch"
+)
+
+// parseFitMode returns the fit mode of a request, contain when empty
+func parseFitMode(fit string) (FitMode, error) {
+	switch FitMode(fit) {
+	case "":
+		return FitContain, nil
+	case FitContain, FitCover, FitStretch:
+		return FitMode(fit), nil
+	}
+	return "", fmt.Errorf("invalid fit mode %q", fit)
+}
+
+// fitGeometry is where the scaled source is placed in the output image
+type fitGeometry struct {
+	// Width and Height of the scaled source
+	Width, Height int
+	// Left and Top of the scaled source in the output, negative when it is cropped
+	Left, Top int
+}
+
+// fit returns how a srcWidth x srcHeight image is scaled and placed into width x height
+func fit(mode FitMode, srcWidth, srcHeight, width, height int) fitGeometry {
+	if mode == FitStretch {
+		return fitGeometry{Width: width, Height: height}
+	}
+	scaleX := float64(width) / float64(srcWidth)
+	scaleY := float64(height) / float64(srcHeight)
+	var g fitGeometry
+	if mode == FitCover {
+		scale := math.Max(scaleX, scaleY)
+		g.Width = max(int(math.Round(float64(srcWidth)*scale)), width)
+		g.Height = max(int(math.Round(float64(srcHeight)*scale)), height)
+	} else {
+		scale := math.Min(scaleX, scaleY)
+		g.Width = min(max(int(math.Round(float64(srcWidth)*scale)), 1), width)
+		g.Height = min(max(int(math.Round(float64(srcHeight)*scale)), 1), height)
+	}
+	// Centered, overflowing areas are negative
+	g.Left = (width - g.Width) / 2
+	g.Top = (height - g.Height) / 2
+	return g
+}
+
+// resizeImage fits the image at srcPath into width x height and writes it in format to savePath
+func resizeImage(srcPath string, width, height int, mode FitMode, format Format, opts ExportOptions, savePath string) error {
+	img, err := vips.NewImageFromFile(srcPath)
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	g := fit(mode, img.Width(), img.Height(), width, height)
+	err = img.ThumbnailWithSize(g.Width, g.Height, vips.InterestingNone, vips.SizeForce)
+	if err != nil {
+		return err
+	}
+	if g.Left < 0 || g.Top < 0 {
+		err = img.ExtractArea(-g.Left, -g.Top, width, height)
+	} else if g.Width != width || g.Height != height {
+		err = img.EmbedBackground(g.Left, g.Top, width, height, &vips.Color{
+			R: 0,
+			G: 0,
+			B: 0,
+		})
+	}
+	if err != nil {
+		return err
+	}
+	// TODO: maybe it isn't a good idea to write to a buffer? (memory consumption)
+	out, metadata, err := export(img, format, opts)
+	if err != nil {
+		return err
+	}
+	// The previous rendition may be served meanwhile
+	err = writeFileAtomic(savePath, func(w io.Writer) error {
+		_, err := w.Write(out)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Resize (%s): %s -> %s, %dx%d", mode, srcPath, savePath, metadata.Width, metadata.Height)
+
+	return nil
+}
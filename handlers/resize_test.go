@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"testing"
+)
+
+func TestParseFitMode(t *testing.T) {
+	tests := []struct {
+		fit     string
+		want    FitMode
+		wantErr bool
+	}{
+		{fit: "", want: FitContain},
+		{fit: "contain", want: FitContain},
+		{fit: "cover", want: FitCover},
+		{fit: "stretch", want: FitStretch},
+		{fit: "fill", wantErr: true},
+		{fit: "Cover", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseFitMode(tt.fit)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("expected error %t for %q but got %v", tt.wantErr, tt.fit, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("expected %q for %q but got %q", tt.want, tt.fit, got)
+		}
+	}
+}
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		name                string
+		mode                FitMode
+		srcWidth, srcHeight int
+		width, height       int
+		want                fitGeometry
+	}{
+		// A square full disk
+		{name: "contain square in landscape", mode: FitContain, srcWidth: 5500, srcHeight: 5500, width: 1920, height: 1080,
+			want: fitGeometry{Width: 1080, Height: 1080, Left: 420}},
+		{name: "contain square in portrait", mode: FitContain, srcWidth: 5500, srcHeight: 5500, width: 1080, height: 1920,
+			want: fitGeometry{Width: 1080, Height: 1080, Top: 420}},
+		{name: "contain square in square", mode: FitContain, srcWidth: 5500, srcHeight: 5500, width: 800, height: 800,
+			want: fitGeometry{Width: 800, Height: 800}},
+		{name: "cover square in landscape", mode: FitCover, srcWidth: 5500, srcHeight: 5500, width: 1920, height: 1080,
+			want: fitGeometry{Width: 1920, Height: 1920, Top: -420}},
+		{name: "cover square in portrait", mode: FitCover, srcWidth: 5500, srcHeight: 5500, width: 1080, height: 1920,
+			want: fitGeometry{Width: 1920, Height: 1920, Left: -420}},
+		{name: "stretch square in landscape", mode: FitStretch, srcWidth: 5500, srcHeight: 5500, width: 1920, height: 1080,
+			want: fitGeometry{Width: 1920, Height: 1080}},
+
+		// A landscape source, like the cropped GOES image
+		{name: "contain landscape in square", mode: FitContain, srcWidth: 4000, srcHeight: 3000, width: 800, height: 800,
+			want: fitGeometry{Width: 800, Height: 600, Top: 100}},
+		{name: "contain landscape in wider landscape", mode: FitContain, srcWidth: 4000, srcHeight: 3000, width: 1920, height: 1080,
+			want: fitGeometry{Width: 1440, Height: 1080, Left: 240}},
+		{name: "contain landscape in portrait", mode: FitContain, srcWidth: 4000, srcHeight: 3000, width: 1080, height: 1920,
+			want: fitGeometry{Width: 1080, Height: 810, Top: 555}},
+		{name: "cover landscape in square", mode: FitCover, srcWidth: 4000, srcHeight: 3000, width: 800, height: 800,
+			want: fitGeometry{Width: 1067, Height: 800, Left: -133}},
+		{name: "cover landscape in portrait", mode: FitCover, srcWidth: 4000, srcHeight: 3000, width: 1080, height: 1920,
+			want: fitGeometry{Width: 2560, Height: 1920, Left: -740}},
+		{name: "stretch landscape in portrait", mode: FitStretch, srcWidth: 4000, srcHeight: 3000, width: 1080, height: 1920,
+			want: fitGeometry{Width: 1080, Height: 1920}},
+
+		// A portrait source
+		{name: "contain portrait in landscape", mode: FitContain, srcWidth: 1000, srcHeight: 2000, width: 1920, height: 1080,
+			want: fitGeometry{Width: 540, Height: 1080, Left: 690}},
+		{name: "cover portrait in landscape", mode: FitCover, srcWidth: 1000, srcHeight: 2000, width: 1920, height: 1080,
+			want: fitGeometry{Width: 1920, Height: 3840, Top: -1380}},
+
+		// Sources with an extreme aspect ratio keep at least a pixel
+		{name: "contain strip", mode: FitContain, srcWidth: 10000, srcHeight: 1, width: 100, height: 100,
+			want: fitGeometry{Width: 100, Height: 1, Top: 49}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fit(tt.mode, tt.srcWidth, tt.srcHeight, tt.width, tt.height)
+			if got != tt.want {
+				t.Errorf("expected %+v but got %+v", tt.want, got)
+			}
+			// Contained sources are inside the output, covered ones fill it
+			switch tt.mode {
+			case FitContain:
+				if got.Left < 0 || got.Top < 0 || got.Left+got.Width > tt.width || got.Top+got.Height > tt.height {
+					t.Errorf("%+v isn't inside %dx%d", got, tt.width, tt.height)
+				}
+			case FitCover:
+				if got.Left > 0 || got.Top > 0 || got.Left+got.Width < tt.width || got.Top+got.Height < tt.height {
+					t.Errorf("%+v doesn't fill %dx%d", got, tt.width, tt.height)
+				}
+			}
+		})
+	}
+}
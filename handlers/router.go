@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// RouteHandler handles a routed request, vars holds the path segments captured by the route by name
+type RouteHandler func(w http.ResponseWriter, r *http.Request, vars map[string]string)
+
+// route is a path pattern split in segments, "{name}" segments capture any non empty segment
+type route struct {
+	methods []string
+	pattern []string
+	handler RouteHandler
+}
+
+// Router dispatches requests to the first route of its table matching the path, in the order they were added
+// Paths matching no route are 404 and paths matching only routes of other methods are 405, both with a JSON error
+type Router struct {
+	routes []route
+}
+
+// Handle adds a route for a pattern like /{source}/max, GET routes also handle HEAD
+func (rt *Router) Handle(pattern string, h RouteHandler, methods ...string) {
+	if slices.Contains(methods, http.MethodGet) && !slices.Contains(methods, http.MethodHead) {
+		methods = append(methods, http.MethodHead)
+	}
+	rt.routes = append(rt.routes, route{methods: methods, pattern: splitPath(pattern), handler: h})
+}
+
+// HandleFunc adds a route for a handler without path variables
+func (rt *Router) HandleFunc(pattern string, h http.HandlerFunc, methods ...string) {
+	rt.Handle(pattern, func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+		h(w, r)
+	}, methods...)
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := splitPath(r.URL.Path)
+	var allowed []string
+	for _, route := range rt.routes {
+		vars, ok := route.match(segments)
+		if !ok {
+			continue
+		}
+		if !slices.Contains(route.methods, r.Method) {
+			allowed = append(allowed, route.methods...)
+			continue
+		}
+		route.handler(w, r, vars)
+		return
+	}
+	if len(allowed) > 0 {
+		slices.Sort(allowed)
+		w.Header().Set("Allow", strings.Join(slices.Compact(allowed), ", "))
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeError(w, http.StatusNotFound, "not found")
+}
+
+// match returns the captured variables of the path segments when they match the route
+func (rt route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(rt.pattern) {
+		return nil, false
+	}
+	vars := map[string]string{}
+	for i, p := range rt.pattern {
+		if strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}") {
+			if segments[i] == "" {
+				return nil, false
+			}
+			vars[p[1:len(p)-1]] = segments[i]
+		} else if p != segments[i] {
+			return nil, false
+		}
+	}
+	return vars, true
+}
+
+// splitPath returns the segments of a path, / has a single empty segment
+func splitPath(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "/"), "/")
+}
+
+// apiError is the body of every error response
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// writeError responds with a JSON error
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{Error: message})
+}
+
+// writeJSON responds with v encoded as JSON
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write JSON response: %s", err)
+	}
+}
+
+// NewRouter returns the routes of the server
+func NewRouter() *Router {
+	rt := &Router{}
+	rt.HandleFunc("/healthz", HealthHandler, http.MethodGet)
+	rt.HandleFunc("/r", RedirectorHandler, http.MethodGet)
+	rt.HandleFunc("/api/sources", SourcesHandler, http.MethodGet)
+	rt.HandleFunc("/api/palettes", PalettesHandler, http.MethodGet)
+	rt.Handle("/{source}/max", MaxHandler, http.MethodGet)
+	rt.Handle("/{source}/{size}", ImageHandler, http.MethodGet)
+	return rt
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouterMatch(t *testing.T) {
+	var got map[string]string
+	var gotRoute string
+	rt := &Router{}
+	for _, pattern := range []string{"/healthz", "/api/sources", "/{source}/max", "/{source}/{size}"} {
+		pattern := pattern
+		rt.Handle(pattern, func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+			gotRoute, got = pattern, vars
+		}, http.MethodGet)
+	}
+
+	tests := []struct {
+		path      string
+		wantRoute string
+		wantVars  map[string]string
+	}{
+		{path: "/healthz", wantRoute: "/healthz", wantVars: map[string]string{}},
+		{path: "/api/sources", wantRoute: "/api/sources", wantVars: map[string]string{}},
+		{path: "/goes/max", wantRoute: "/{source}/max", wantVars: map[string]string{"source": "goes"}},
+		{path: "/goes/800x600.webp", wantRoute: "/{source}/{size}", wantVars: map[string]string{"source": "goes", "size": "800x600.webp"}},
+		// Routes are matched in order, /api/{size} is shadowed by /api/sources only
+		{path: "/api/800x600", wantRoute: "/{source}/{size}", wantVars: map[string]string{"source": "api", "size": "800x600"}},
+	}
+	for _, tt := range tests {
+		gotRoute, got = "", nil
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if gotRoute != tt.wantRoute {
+			t.Errorf("expected %s to be routed to %s but got %q (%d)", tt.path, tt.wantRoute, gotRoute, rec.Code)
+			continue
+		}
+		if len(got) != len(tt.wantVars) {
+			t.Errorf("expected vars %v for %s but got %v", tt.wantVars, tt.path, got)
+		}
+		for k, v := range tt.wantVars {
+			if got[k] != v {
+				t.Errorf("expected vars %v for %s but got %v", tt.wantVars, tt.path, got)
+			}
+		}
+	}
+}
+
+func TestRouterErrors(t *testing.T) {
+	tests := []struct {
+		method     string
+		path       string
+		wantStatus int
+		wantAllow  string
+	}{
+		{method: http.MethodGet, path: "/", wantStatus: http.StatusNotFound},
+		{method: http.MethodGet, path: "/goes", wantStatus: http.StatusNotFound},
+		{method: http.MethodGet, path: "/goes/", wantStatus: http.StatusNotFound},
+		{method: http.MethodGet, path: "/goes/800x600/", wantStatus: http.StatusNotFound},
+		{method: http.MethodGet, path: "/a/b/c", wantStatus: http.StatusNotFound},
+		{method: http.MethodGet, path: "/unknown/800x600", wantStatus: http.StatusNotFound},
+		{method: http.MethodGet, path: "/unknown/max", wantStatus: http.StatusNotFound},
+		{method: http.MethodPost, path: "/goes/800x600", wantStatus: http.StatusMethodNotAllowed, wantAllow: "GET, HEAD"},
+		{method: http.MethodDelete, path: "/healthz", wantStatus: http.StatusMethodNotAllowed, wantAllow: "GET, HEAD"},
+		{method: http.MethodGet, path: "/goes/800", wantStatus: http.StatusBadRequest},
+		{method: http.MethodGet, path: "/goes/0x600", wantStatus: http.StatusBadRequest},
+		{method: http.MethodGet, path: "/goes/800x600.gif", wantStatus: http.StatusBadRequest},
+		{method: http.MethodGet, path: "/goes/800x600?fit=fill", wantStatus: http.StatusBadRequest},
+		{method: http.MethodGet, path: "/goes/800x600?quality=0", wantStatus: http.StatusBadRequest},
+		{method: http.MethodGet, path: "/himawari-ir/800x600?palette=unknown", wantStatus: http.StatusBadRequest},
+		{method: http.MethodGet, path: "/goes/800x600?palette=bd", wantStatus: http.StatusBadRequest},
+		{method: http.MethodGet, path: "/himawari/800x600?palette=rainbow", wantStatus: http.StatusBadRequest},
+		{method: http.MethodGet, path: "/himawari-truecolor/max?palette=bd", wantStatus: http.StatusBadRequest},
+	}
+	rt := NewRouter()
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.wantStatus {
+			t.Errorf("expected %d for %s %s but got %d", tt.wantStatus, tt.method, tt.path, rec.Code)
+			continue
+		}
+		if got := rec.Header().Get("Allow"); got != tt.wantAllow {
+			t.Errorf("expected Allow %q for %s %s but got %q", tt.wantAllow, tt.method, tt.path, got)
+		}
+		if got := rec.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("expected a JSON error for %s %s but got %s", tt.method, tt.path, got)
+		}
+		var body apiError
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error == "" {
+			t.Errorf("expected a JSON error for %s %s but got %q", tt.method, tt.path, rec.Body.String())
+		}
+	}
+}
+
+func TestRouterAPI(t *testing.T) {
+	rt := NewRouter()
+	tests := []struct {
+		path string
+		key  string
+	}{
+		{path: "/api/sources", key: "sources"},
+		{path: "/api/palettes", key: "palettes"},
+		{path: "/healthz", key: "status"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("expected 200 for %s but got %d", tt.path, rec.Code)
+			continue
+		}
+		var body map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Errorf("expected JSON for %s but got %q", tt.path, rec.Body.String())
+			continue
+		}
+		if _, ok := body[tt.key]; !ok {
+			t.Errorf("expected %q in %s but got %v", tt.key, tt.path, body)
+		}
+	}
+
+	// The max resolution redirects to the source
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/goes/max", nil))
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") == "" {
+		t.Errorf("expected a redirect for /goes/max but got %d", rec.Code)
+	}
+}
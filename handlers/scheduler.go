@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"matbm.net/geonow/config"
+	"matbm.net/geonow/imagery"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// popularThumbs is how many of the most requested thumbs of a source are rendered after every refresh
+	popularThumbs = 5
+	// maxTrackedThumbs is how many thumbs of a source are counted at once, others are ignored until hits fade
+	maxTrackedThumbs = 100
+	// refreshRetry is how long a scheduled source waits after a failed refresh
+	refreshRetry = time.Minute
+)
+
+// Scheduler refreshes the latest image of its sources in the background and renders their most requested thumbs,
+// so requests are served from the cache and only download a source on a cold start
+type Scheduler struct {
+	mu      sync.Mutex
+	sources map[string]*scheduledSource
+	// refresh and render update the cache, replaced in tests
+	refresh func(ctx context.Context, src imagery.ImageSource, cacheName string) error
+	render  func(cacheName string, t thumb) error
+}
+
+type scheduledSource struct {
+	src      imagery.ImageSource
+	interval time.Duration
+	// hits counts the requests of every thumb by file name, halved after every refresh so old requests fade
+	hits map[string]*thumbHits
+}
+
+type thumbHits struct {
+	thumb thumb
+	hits  int
+}
+
+// DefaultScheduler is the scheduler of the sources of config.DefaultConfig.RefreshIntervals
+var DefaultScheduler = NewScheduler()
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{sources: map[string]*scheduledSource{}, refresh: refreshSource, render: renderThumb}
+}
+
+// Register adds a source refreshed every interval, cacheName is the name of its cached files
+// Sources have to be registered before Run
+func (s *Scheduler) Register(cacheName string, src imagery.ImageSource, interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sources[cacheName] = &scheduledSource{src: src, interval: interval, hits: map[string]*thumbHits{}}
+}
+
+// RegisterSources registers the sources of config.DefaultConfig.RefreshIntervals with their default palette
+func (s *Scheduler) RegisterSources() error {
+	for name, interval := range config.DefaultConfig.RefreshIntervals {
+		src, err := getSource(name, "")
+		if err != nil {
+			return err
+		}
+		s.Register(name, src, interval)
+	}
+	return nil
+}
+
+// Scheduled returns whether a source is refreshed by the scheduler
+func (s *Scheduler) Scheduled(cacheName string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.sources[cacheName]
+	return ok
+}
+
+// Requested records a successfully served thumb of a source, thumbs bigger than the max dimensions are never counted
+func (s *Scheduler) Requested(cacheName string, t thumb) {
+	if t.width > config.DefaultConfig.MaxWidth || t.height > config.DefaultConfig.MaxHeight {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss, ok := s.sources[cacheName]
+	if !ok {
+		return
+	}
+	file := t.file()
+	if h, ok := ss.hits[file]; ok {
+		h.hits++
+	} else if len(ss.hits) < maxTrackedThumbs {
+		ss.hits[file] = &thumbHits{thumb: t, hits: 1}
+	}
+}
+
+// popular returns the most requested thumbs of a source, most requested first, and halves their hits
+func (s *Scheduler) popular(cacheName string) []thumb {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss := s.sources[cacheName]
+	all := make([]*thumbHits, 0, len(ss.hits))
+	for _, h := range ss.hits {
+		all = append(all, h)
+	}
+	// Ties keep a stable order by file name
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].hits != all[j].hits {
+			return all[i].hits > all[j].hits
+		}
+		return all[i].thumb.file() < all[j].thumb.file()
+	})
+	thumbs := make([]thumb, 0, popularThumbs)
+	for _, h := range all[:min(len(all), popularThumbs)] {
+		thumbs = append(thumbs, h.thumb)
+	}
+
+	for file, h := range ss.hits {
+		if h.hits /= 2; h.hits == 0 {
+			delete(ss.hits, file)
+		}
+	}
+	return thumbs
+}
+
+// Run refreshes every source once its cached image is older than its interval, until ctx is done
+func (s *Scheduler) Run(ctx context.Context) {
+	s.mu.Lock()
+	sources := make(map[string]*scheduledSource, len(s.sources))
+	for name, ss := range s.sources {
+		sources[name] = ss
+	}
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for name, ss := range sources {
+		name, ss := name, ss
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.run(ctx, name, ss)
+		}()
+	}
+	wg.Wait()
+}
+
+// run refreshes a source on its interval, a cache that is still fresh on start waits for the rest of it
+func (s *Scheduler) run(ctx context.Context, cacheName string, ss *scheduledSource) {
+	for {
+		lastRefresh, _ := modTime(imagePath(cacheName, "latest-clean.jpg"))
+		if !sleep(ctx, time.Until(lastRefresh.Add(ss.interval))) {
+			return
+		}
+		if err := s.refresh(ctx, ss.src, cacheName); err != nil {
+			log.Printf("Error refreshing %s image: %v", cacheName, err)
+			if !sleep(ctx, refreshRetry) {
+				return
+			}
+			continue
+		}
+		for _, t := range s.popular(cacheName) {
+			if err := s.render(cacheName, t); err != nil {
+				log.Printf("Error rendering %s %s: %v", cacheName, t.file(), err)
+			}
+		}
+	}
+}
+
+// sleep waits for d, returning false when ctx is done first
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"context"
+	"matbm.net/geonow/config"
+	"matbm.net/geonow/imagery"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSchedulerRequested(t *testing.T) {
+	s := NewScheduler()
+	s.Register("goes", &fakeSource{}, time.Minute)
+
+	if s.Scheduled("himawari") || !s.Scheduled("goes") {
+		t.Errorf("expected only the registered source to be scheduled")
+	}
+	s.Requested("himawari", thumb{width: 800, height: 600, mode: FitContain, format: FormatJPEG})
+	if _, ok := s.sources["himawari"]; ok {
+		t.Errorf("expected an unregistered source not to be counted")
+	}
+
+	// 6 thumbs requested from 1 to 6 times
+	var thumbs []thumb
+	for i := 1; i <= popularThumbs+1; i++ {
+		th := thumb{width: 100 * i, height: 100, mode: FitContain, format: FormatJPEG}
+		thumbs = append(thumbs, th)
+		for j := 0; j < i; j++ {
+			s.Requested("goes", th)
+		}
+	}
+	want := slices.Clone(thumbs[1:])
+	slices.Reverse(want)
+	if got := s.popular("goes"); !slices.Equal(got, want) {
+		t.Errorf("expected the %d most requested thumbs %v but got %v", popularThumbs, want, got)
+	}
+
+	// Hits are halved, the thumb requested once is forgotten
+	if got := len(s.sources["goes"].hits); got != popularThumbs {
+		t.Errorf("expected %d thumbs left but got %d", popularThumbs, got)
+	}
+	if got := s.sources["goes"].hits[thumbs[5].file()].hits; got != 3 {
+		t.Errorf("expected 3 hits left but got %d", got)
+	}
+}
+
+func TestSchedulerRequestedLimits(t *testing.T) {
+	s := NewScheduler()
+	s.Register("goes", &fakeSource{}, time.Minute)
+
+	s.Requested("goes", thumb{width: config.DefaultConfig.MaxWidth + 1, height: 100, mode: FitContain, format: FormatJPEG})
+	s.Requested("goes", thumb{width: 100, height: config.DefaultConfig.MaxHeight + 1, mode: FitContain, format: FormatJPEG})
+	if got := len(s.sources["goes"].hits); got != 0 {
+		t.Errorf("expected thumbs bigger than the max dimensions not to be counted but got %d", got)
+	}
+
+	for i := 1; i <= maxTrackedThumbs+10; i++ {
+		s.Requested("goes", thumb{width: i, height: 100, mode: FitContain, format: FormatJPEG})
+	}
+	if got := len(s.sources["goes"].hits); got != maxTrackedThumbs {
+		t.Errorf("expected %d thumbs counted but got %d", maxTrackedThumbs, got)
+	}
+	// Thumbs already counted keep counting
+	first := thumb{width: 1, height: 100, mode: FitContain, format: FormatJPEG}
+	s.Requested("goes", first)
+	if got := s.sources["goes"].hits[first.file()].hits; got != 2 {
+		t.Errorf("expected 2 hits but got %d", got)
+	}
+}
+
+func TestImageHandlerRequested(t *testing.T) {
+	testCacheDir(t)
+	previous := DefaultScheduler
+	DefaultScheduler = NewScheduler()
+	t.Cleanup(func() { DefaultScheduler = previous })
+	DefaultScheduler.Register("goes", &fakeSource{}, time.Hour)
+	// Not a jpeg, rendering thumbs from it fails
+	if err := os.WriteFile(imagePath("goes", "latest-clean.jpg"), []byte("image"), 0660); err != nil {
+		t.Fatal(err)
+	}
+
+	th := thumb{width: 800, height: 600, mode: FitContain, format: FormatJPEG}
+	hits := func() int {
+		if h, ok := DefaultScheduler.sources["goes"].hits[th.file()]; ok {
+			return h.hits
+		}
+		return 0
+	}
+	rt := NewRouter()
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/goes/800x600.jpg", nil))
+	if rec.Code != http.StatusInternalServerError || hits() != 0 {
+		t.Errorf("expected a failed render not to be counted but got %d with %d hits", rec.Code, hits())
+	}
+
+	// Thumb rendered by a previous refresh
+	if err := os.WriteFile(imagePath("goes", th.file()), []byte("thumb"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/goes/800x600.jpg", nil))
+	if rec.Code != http.StatusOK || hits() != 1 {
+		t.Errorf("expected a served thumb to be counted but got %d with %d hits", rec.Code, hits())
+	}
+}
+
+// recordingScheduler returns a scheduler writing the cache of its refreshes and recording them and its renders
+func recordingScheduler() (*Scheduler, func() (int, []string)) {
+	s := NewScheduler()
+	var mu sync.Mutex
+	var refreshes int
+	var renders []string
+	s.refresh = func(_ context.Context, _ imagery.ImageSource, cacheName string) error {
+		mu.Lock()
+		refreshes++
+		mu.Unlock()
+		return os.WriteFile(imagePath(cacheName, "latest-clean.jpg"), []byte("image"), 0660)
+	}
+	s.render = func(cacheName string, th thumb) error {
+		mu.Lock()
+		renders = append(renders, cacheName+"-"+th.file())
+		mu.Unlock()
+		return nil
+	}
+	return s, func() (int, []string) {
+		mu.Lock()
+		defer mu.Unlock()
+		return refreshes, slices.Clone(renders)
+	}
+}
+
+func TestSchedulerRun(t *testing.T) {
+	testCacheDir(t)
+	s, recorded := recordingScheduler()
+	s.Register("goes", &fakeSource{}, 30*time.Millisecond)
+	s.Requested("goes", thumb{width: 800, height: 600, mode: FitContain, format: FormatJPEG})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	s.Run(ctx)
+
+	refreshes, renders := recorded()
+	// Refreshed on start since nothing is cached, then every interval
+	if refreshes < 2 {
+		t.Errorf("expected at least 2 refreshes but got %d", refreshes)
+	}
+	// Rendered after the first refresh, then forgotten once its hit is halved
+	if !slices.Equal(renders, []string{"goes-800x600.jpg"}) {
+		t.Errorf("expected the requested thumb to be rendered once but got %v", renders)
+	}
+}
+
+func TestSchedulerRunFreshCache(t *testing.T) {
+	testCacheDir(t)
+	if err := os.WriteFile(imagePath("goes", "latest-clean.jpg"), []byte("image"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	s, recorded := recordingScheduler()
+	s.Register("goes", &fakeSource{}, time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	s.Run(ctx)
+
+	if refreshes, _ := recorded(); refreshes != 0 {
+		t.Errorf("expected a fresh cache not to be refreshed but got %d refreshes", refreshes)
+	}
+}
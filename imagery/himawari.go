@@ -0,0 +1,185 @@
+package imagery
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/davidbyttow/govips/v2/vips"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"log"
+	"matbm.net/geonow/imagery/himawari"
+	"net/http"
+	"time"
+)
+
+const (
+	himawariSatellite = "H09"
+	himawariBand      = 3
+	// himawariInfraredBand is the clean longwave window shown on the night side of day/night renders
+	himawariInfraredBand = 13
+	// himawariDelay how long it takes for a full disk observation to be available after it starts
+	himawariDelay = 20 * time.Minute
+	// himawariSegmentTimeout is how long downloading a segment can take, it is streamed while decoded
+	himawariSegmentTimeout = 5 * time.Minute
+)
+
+// himawariClient downloads the segments, a stalled download fails instead of blocking the refresh forever
+var himawariClient = &http.Client{Timeout: himawariSegmentTimeout}
+
+// himawariNoData is the colour of segments that failed to download, lighter than space so gaps are noticeable
+var himawariNoData = color.RGBA{R: 48, G: 48, B: 48, A: 255}
+
+type HimawariSource struct {
+	MaxWidth int
+	// BaseURL is where the AHI-L1b-FLDK tree is served from, e.g. the NOAA open data bucket
+	BaseURL string
+	// Band is the AHI band to render
+	Band int
+	// TrueColor renders bands 1, 2 and 3 as a true colour composite instead of Band
+	TrueColor bool
+	// DayNight renders the true colour composite on the day side and band 13 on the night side, instead of Band
+	DayNight bool
+	// Palette colours infrared bands by brightness temperature, nil is inverted greyscale
+	Palette *himawari.Palette
+	// Time of the observation to download, zero means the latest available one
+	Time time.Time
+}
+
+// DownloadImage downloads and decodes the ten full disk segments of a band, returning the rendered jpeg
+func (h HimawariSource) DownloadImage() (*bufio.Reader, error) {
+	r, _, err := h.DownloadTimedImage(context.Background())
+	return r, err
+}
+
+// DownloadTimedImage is DownloadImage also returning when the observation started, according to its first segment
+// Segments are downloaded while decoded, canceling ctx stops every download
+func (h HimawariSource) DownloadTimedImage(ctx context.Context) (*bufio.Reader, time.Time, error) {
+	t := h.observationTime()
+	res, err := h.decode(ctx, t)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if len(res.Missing) > 0 {
+		log.Printf("Himawari observation %s is missing segments %v", t.Format(time.RFC3339), res.Missing)
+	}
+
+	buf := &bytes.Buffer{}
+	err = jpeg.Encode(buf, res.Image, &jpeg.Options{Quality: 90})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	return bufio.NewReader(buf), res.Header.BasicInfo.StartTime(), nil
+}
+
+// decode downloads and decodes the bands of the observation starting at t
+func (h HimawariSource) decode(ctx context.Context, t time.Time) (*himawari.Result, error) {
+	if h.DayNight {
+		return himawari.DayNightFullDisk(ctx, h.segmentOpener(t, 1), h.segmentOpener(t, 2), h.segmentOpener(t, 3),
+			h.segmentOpener(t, himawariInfraredBand), h.options(1))
+	}
+	if h.TrueColor {
+		return himawari.TrueColorFullDisk(ctx, h.segmentOpener(t, 1), h.segmentOpener(t, 2), h.segmentOpener(t, 3), h.options(1))
+	}
+	return himawari.DecodeFullDisk(ctx, h.segmentOpener(t, h.Band), h.options(h.Band))
+}
+
+// PostProcess Resizes the full disk to the max width
+func (h HimawariSource) PostProcess(src io.Reader, dst io.Writer) error {
+	img, err := vips.NewImageFromReader(src)
+	if err != nil {
+		return err
+	}
+	err = img.Thumbnail(h.MaxWidth, h.MaxWidth, vips.InterestingNone)
+	if err != nil {
+		return err
+	}
+	out, metadata, err := img.ExportJpeg(nil)
+	if err != nil {
+		return err
+	}
+
+	n, err := dst.Write(out)
+	if err != nil {
+		return err
+	}
+	log.Printf("Himawari post process: %dx%d %d bytes", metadata.Width, metadata.Height, n)
+
+	return nil
+}
+
+// SourceURL Returns the directory holding the segments of the observation
+func (h HimawariSource) SourceURL() string {
+	return h.BaseURL + h.observationDir(h.observationTime())
+}
+
+// observationTime returns the configured time or the start of the latest available observation
+func (h HimawariSource) observationTime() time.Time {
+	if !h.Time.IsZero() {
+		return h.Time.UTC()
+	}
+	return time.Now().UTC().Add(-himawariDelay).Truncate(himawari.ObservationInterval)
+}
+
+// observationDir returns the path of an observation, e.g. /AHI-L1b-FLDK/2023/11/30/0030/
+func (h HimawariSource) observationDir(t time.Time) string {
+	return "/AHI-L1b-FLDK/" + t.Format("2006/01/02/1504/")
+}
+
+// segmentURL returns the URL of a bzip2 compressed segment
+func (h HimawariSource) segmentURL(t time.Time, band int, segment int) string {
+	return h.BaseURL + h.observationDir(t) + himawari.SegmentName(himawariSatellite, t, band, segment) + ".bz2"
+}
+
+// downsample returns the smallest downsample of a band that renders at most MaxWidth pixels, the decoded planes are
+// never bigger than the image PostProcess resizes to
+func (h HimawariSource) downsample(band int) int {
+	width := max(h.MaxWidth, 1)
+	return max((himawari.DiskSize(band)+width-1)/width, 1)
+}
+
+// options returns the decode options of a band, partial downloads are rendered with the missing segments in grey
+func (h HimawariSource) options(band int) himawari.Options {
+	return himawari.Options{
+		Downsample:   h.downsample(band),
+		Filter:       himawari.FilterBox,
+		AllowMissing: true,
+		NoData:       himawariNoData,
+		Palette:      h.Palette,
+	}
+}
+
+// segmentOpener returns the opener of the segments of a band, segments that fail to download are missing
+func (h HimawariSource) segmentOpener(t time.Time, band int) himawari.SegmentOpener {
+	return func(ctx context.Context, segment int) (io.ReadCloser, error) {
+		rc, err := h.downloadSegment(ctx, t, band, segment)
+		if err != nil {
+			log.Printf("Failed to download himawari segment %d of band %d: %s", segment, band, err)
+		}
+		return rc, err
+	}
+}
+
+// downloadSegment returns the decompressed body of a segment, which is decoded as it streams
+func (h HimawariSource) downloadSegment(ctx context.Context, t time.Time, band int, segment int) (io.ReadCloser, error) {
+	url := h.segmentURL(t, band, segment)
+	log.Printf("Downloading himawari segment %s", url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := himawariClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+
+	return himawari.NewBzip2Reader(resp.Body), nil
+}
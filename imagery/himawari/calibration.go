@@ -0,0 +1,97 @@
+package himawari
+
+import "math"
+
+// InvalidValue is used by the HSD header for coefficients and dates that aren't available, like the GSICS correction
+// of visible bands
+const InvalidValue = -10000000000.0
+
+// IsInfrared returns if the segment holds an infrared band (7 to 16)
+func (f *HMFile) IsInfrared() bool {
+	return f.CalibrationInfo.BandNumber >= 7
+}
+
+// ValidCount returns if a count is an observed pixel, instead of an error or outside the scan area pixel
+func (f *HMFile) ValidCount(count uint16) bool {
+	return count != f.CalibrationInfo.CountValueOfErrorPixels && count != f.CalibrationInfo.CountValueOfPixelsOutsideScanArea
+}
+
+// HasGSICS returns if the file carries a valid GSICS inter calibration correction
+func (ci InterCalibrationInformationBlock) HasGSICS() bool {
+	return ci.GSICSSlope != InvalidValue && ci.GSICSIntercept != InvalidValue && ci.GSICSSlope != 0
+}
+
+// Radiance converts a count into radiance [W/(m² sr μm)], applying the GSICS correction when the file provides it
+// Returns NaN for error and outside the scan area pixels
+func (f *HMFile) Radiance(count uint16) float64 {
+	if !f.ValidCount(count) {
+		return math.NaN()
+	}
+	c := f.CalibrationInfo
+	r := c.SlopeForCountRadianceEq*float64(count) + c.InterceptForCountRadianceEq
+
+	ci := f.InterCalibrationInfo
+	if !ci.HasGSICS() || !ci.gsicsValidAt(f.BasicInfo.ObservationStartTime) {
+		return r
+	}
+	// The correction is only valid in the radiance range it was computed for
+	if ci.GSICSCalibrationLowerLimit != InvalidValue && r < float64(ci.GSICSCalibrationLowerLimit) {
+		return r
+	}
+	if ci.GSICSCalibrationUpperLimit != InvalidValue && r > float64(ci.GSICSCalibrationUpperLimit) {
+		return r
+	}
+	// GSICS models the observed radiance as intercept + slope*L + quadratic*L², solved for the corrected L with the
+	// root that is (r - intercept) / slope without a quadratic term
+	quadratic := ci.GSICSQuadratic
+	if quadratic == InvalidValue {
+		quadratic = 0
+	}
+	d := ci.GSICSSlope*ci.GSICSSlope + 4*quadratic*(r-ci.GSICSIntercept)
+	if d < 0 {
+		return r
+	}
+	return 2 * (r - ci.GSICSIntercept) / (ci.GSICSSlope + math.Copysign(math.Sqrt(d), ci.GSICSSlope))
+}
+
+// gsicsValidAt returns if the GSICS correction applies to an observation at a modified julian date, unset bounds of
+// the validity period don't limit it
+func (ci InterCalibrationInformationBlock) gsicsValidAt(mjd float64) bool {
+	if ci.GSICSCorrectionStart != InvalidValue && ci.GSICSCorrectionStart > 0 && mjd < ci.GSICSCorrectionStart {
+		return false
+	}
+	if ci.GSICSCorrectionEnd != InvalidValue && ci.GSICSCorrectionEnd > 0 && mjd > ci.GSICSCorrectionEnd {
+		return false
+	}
+	return true
+}
+
+// Albedo converts a count of a visible band (1 to 6) into albedo (reflectance), usually between 0 and 1
+// Returns NaN for infrared bands, error and outside the scan area pixels
+func (f *HMFile) Albedo(count uint16) float64 {
+	if f.IsInfrared() {
+		return math.NaN()
+	}
+	return f.CalibrationInfo.Visible.Albedo * f.Radiance(count)
+}
+
+// BrightnessTemperature converts a count of an infrared band (7 to 16) into brightness temperature [K]
+// Returns NaN for visible bands, error and outside the scan area pixels
+func (f *HMFile) BrightnessTemperature(count uint16) float64 {
+	if !f.IsInfrared() {
+		return math.NaN()
+	}
+	r := f.Radiance(count)
+	if !(r > 0) {
+		return math.NaN()
+	}
+	ir := f.CalibrationInfo.Infrared
+	// Wavelength in μm to m and radiance per μm to per m
+	lambda := f.CalibrationInfo.CentralWaveLength * 1e-6
+	r *= 1e6
+	// Inverse Planck function gives the effective brightness temperature
+	hc := ir.PlanckConstant * ir.SpeedOfLight
+	te := hc / (ir.BoltzmannConstant * lambda) / math.Log(2*hc*ir.SpeedOfLight/(math.Pow(lambda, 5)*r)+1)
+
+	return ir.BrightnessTemp + ir.BrightnessC1*te + ir.BrightnessC2*te*te
+}
@@ -0,0 +1,199 @@
+package himawari
+
+import (
+	"math"
+	"testing"
+)
+
+// visibleFile returns the calibration of band 2 from the sample file
+func visibleFile() *HMFile {
+	return &HMFile{
+		CalibrationInfo: CalibrationInformationBlock{
+			BandNumber:                        2,
+			CentralWaveLength:                 0.509930,
+			ValidNumberOfBitsPerPixel:         11,
+			CountValueOfErrorPixels:           65535,
+			CountValueOfPixelsOutsideScanArea: 65534,
+			SlopeForCountRadianceEq:           0.35414147058823525,
+			InterceptForCountRadianceEq:       -7.082829411764705,
+			Visible: VisibleBand{
+				Albedo: 0.00166101782189072,
+			},
+		},
+		InterCalibrationInfo: InterCalibrationInformationBlock{
+			GSICSIntercept:             InvalidValue,
+			GSICSSlope:                 InvalidValue,
+			GSICSCalibrationUpperLimit: InvalidValue,
+			GSICSCalibrationLowerLimit: InvalidValue,
+		},
+	}
+}
+
+// infraredFile returns a band 13 calibration
+func infraredFile() *HMFile {
+	return &HMFile{
+		CalibrationInfo: CalibrationInformationBlock{
+			BandNumber:                        13,
+			CentralWaveLength:                 10.4073,
+			ValidNumberOfBitsPerPixel:         12,
+			CountValueOfErrorPixels:           65535,
+			CountValueOfPixelsOutsideScanArea: 65534,
+			SlopeForCountRadianceEq:           -0.0037440,
+			InterceptForCountRadianceEq:       15.3296,
+			Infrared: InfraredBand{
+				BrightnessTemp:    -0.1,
+				BrightnessC1:      1.0002,
+				BrightnessC2:      -0.0000001,
+				SpeedOfLight:      2.99792458e8,
+				PlanckConstant:    6.62606957e-34,
+				BoltzmannConstant: 1.3806488e-23,
+			},
+		},
+		InterCalibrationInfo: InterCalibrationInformationBlock{
+			GSICSIntercept:             InvalidValue,
+			GSICSSlope:                 InvalidValue,
+			GSICSCalibrationUpperLimit: InvalidValue,
+			GSICSCalibrationLowerLimit: InvalidValue,
+		},
+	}
+}
+
+// planck returns the radiance [W/(m² sr μm)] of a black body at t for a wavelength in μm
+func planck(ir InfraredBand, wavelength float64, t float64) float64 {
+	l := wavelength * 1e-6
+	h, c, k := ir.PlanckConstant, ir.SpeedOfLight, ir.BoltzmannConstant
+	return 2 * h * c * c / (math.Pow(l, 5) * (math.Exp(h*c/(k*l*t)) - 1)) * 1e-6
+}
+
+func TestRadiance(t *testing.T) {
+	f := visibleFile()
+	got := f.Radiance(1000)
+	expected := 0.35414147058823525*1000 - 7.082829411764705
+	if math.Abs(got-expected) > 1e-9 {
+		t.Errorf("expected radiance %f but got %f", expected, got)
+	}
+	if !math.IsNaN(f.Radiance(65534)) || !math.IsNaN(f.Radiance(65535)) {
+		t.Errorf("expected NaN for outside scan area and error pixels")
+	}
+}
+
+func TestRadianceGSICS(t *testing.T) {
+	f := infraredFile()
+	raw := f.Radiance(2000)
+	f.InterCalibrationInfo.GSICSIntercept = 0.1
+	f.InterCalibrationInfo.GSICSSlope = 0.98
+	f.InterCalibrationInfo.GSICSCalibrationLowerLimit = 0
+	f.InterCalibrationInfo.GSICSCalibrationUpperLimit = 20
+
+	got := f.Radiance(2000)
+	expected := (raw - 0.1) / 0.98
+	if math.Abs(got-expected) > 1e-9 {
+		t.Errorf("expected corrected radiance %f but got %f", expected, got)
+	}
+
+	// Outside the validity range the correction isn't applied
+	f.InterCalibrationInfo.GSICSCalibrationUpperLimit = float32(raw - 1)
+	if got = f.Radiance(2000); got != raw {
+		t.Errorf("expected uncorrected radiance %f outside the GSICS range but got %f", raw, got)
+	}
+}
+
+func TestRadianceGSICSQuadratic(t *testing.T) {
+	f := infraredFile()
+	raw := f.Radiance(2000)
+	ci := &f.InterCalibrationInfo
+	ci.GSICSIntercept = 0.1
+	ci.GSICSSlope = 0.98
+	ci.GSICSQuadratic = 0.002
+	ci.GSICSCalibrationLowerLimit = 0
+	ci.GSICSCalibrationUpperLimit = 20
+
+	// The corrected radiance gives back the observed one through the GSICS polynomial
+	got := f.Radiance(2000)
+	if observed := ci.GSICSIntercept + ci.GSICSSlope*got + ci.GSICSQuadratic*got*got; math.Abs(observed-raw) > 1e-9 {
+		t.Errorf("expected corrected radiance %f to be observed as %f but got %f", got, raw, observed)
+	}
+	if linear := (raw - 0.1) / 0.98; got >= linear {
+		t.Errorf("expected a positive quadratic term to lower the corrected radiance %f below %f", got, linear)
+	}
+
+	// An invalid quadratic term is a linear correction
+	ci.GSICSQuadratic = InvalidValue
+	if got, expected := f.Radiance(2000), (raw-0.1)/0.98; math.Abs(got-expected) > 1e-9 {
+		t.Errorf("expected linear corrected radiance %f but got %f", expected, got)
+	}
+}
+
+func TestRadianceGSICSPeriod(t *testing.T) {
+	f := infraredFile()
+	raw := f.Radiance(2000)
+	ci := &f.InterCalibrationInfo
+	ci.GSICSIntercept = 0.1
+	ci.GSICSSlope = 0.98
+	ci.GSICSCalibrationLowerLimit = 0
+	ci.GSICSCalibrationUpperLimit = 20
+	ci.GSICSCorrectionStart = 60240
+	ci.GSICSCorrectionEnd = 60250
+	corrected := (raw - 0.1) / 0.98
+
+	tests := []struct {
+		observation float64
+		start, end  float64
+		want        float64
+	}{
+		{observation: 60248.5, start: 60240, end: 60250, want: corrected},
+		{observation: 60239.9, start: 60240, end: 60250, want: raw},
+		{observation: 60250.1, start: 60240, end: 60250, want: raw},
+		{observation: 60250.1, start: 60240, end: InvalidValue, want: corrected},
+		{observation: 60239.9, start: InvalidValue, end: 60250, want: corrected},
+	}
+	for _, tt := range tests {
+		f.BasicInfo.ObservationStartTime = tt.observation
+		ci.GSICSCorrectionStart, ci.GSICSCorrectionEnd = tt.start, tt.end
+		if got := f.Radiance(2000); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("expected radiance %f at %f in [%f, %f] but got %f", tt.want, tt.observation, tt.start, tt.end, got)
+		}
+	}
+}
+
+func TestAlbedo(t *testing.T) {
+	f := visibleFile()
+	// Max count of 11 bits should be a bright cloud
+	albedo := f.Albedo(2046)
+	if albedo < 1 || albedo > 1.3 {
+		t.Errorf("expected albedo around 1.2 for the max count but got %f", albedo)
+	}
+	if albedo := f.Albedo(20); math.Abs(albedo) > 1e-9 {
+		t.Errorf("expected albedo 0 for count 20 but got %f", albedo)
+	}
+	if !math.IsNaN(infraredFile().Albedo(1000)) {
+		t.Errorf("expected NaN albedo for infrared bands")
+	}
+}
+
+func TestBrightnessTemperature(t *testing.T) {
+	f := infraredFile()
+	ir := f.CalibrationInfo.Infrared
+	for _, count := range []uint16{500, 1500, 2500, 3500} {
+		tb := f.BrightnessTemperature(count)
+		if tb < 150 || tb > 350 {
+			t.Errorf("brightness temperature %f of count %d out of range", tb, count)
+		}
+		// Undo the correction and apply Planck, it should give the radiance back
+		te := (-ir.BrightnessC1 + math.Sqrt(ir.BrightnessC1*ir.BrightnessC1-4*ir.BrightnessC2*(ir.BrightnessTemp-tb))) / (2 * ir.BrightnessC2)
+		r := planck(ir, f.CalibrationInfo.CentralWaveLength, te)
+		if math.Abs(r-f.Radiance(count)) > 1e-6 {
+			t.Errorf("expected radiance %f for %fK but got %f", f.Radiance(count), tb, r)
+		}
+	}
+	// Warmer surfaces have more radiance, that is lower counts
+	if f.BrightnessTemperature(500) <= f.BrightnessTemperature(3500) {
+		t.Errorf("expected lower counts to be warmer")
+	}
+	if !math.IsNaN(f.BrightnessTemperature(65534)) {
+		t.Errorf("expected NaN for outside scan area pixels")
+	}
+	if !math.IsNaN(visibleFile().BrightnessTemperature(1000)) {
+		t.Errorf("expected NaN brightness temperature for visible bands")
+	}
+}
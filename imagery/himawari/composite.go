@@ -0,0 +1,235 @@
+package himawari
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/sync/errgroup"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"slices"
+)
+
+// GreenBlend is how much of the red band (0.64μm) is blended into band 2 (0.51μm) to synthesize green (0.55μm),
+// as AHI has no true green band
+const GreenBlend = 0.15
+
+// plane is a full disk of calibrated values, NaN where there is no data
+type plane struct {
+	width  int
+	height int
+	values []float32
+	// segments are the headers of the decoded segments by sequence number, nil when missing, only set by decodePlane
+	segments []*HMFile
+}
+
+func newPlane(width, height int) *plane {
+	return &plane{width: width, height: height, values: make([]float32, width*height)}
+}
+
+func (p *plane) at(x, y int) float32 {
+	return p.values[y*p.width+x]
+}
+
+// resample returns the plane area averaged (or nearest neighbour when enlarging) into width x height
+func (p *plane) resample(width, height int) *plane {
+	if p.width == width && p.height == height {
+		return p
+	}
+	out := newPlane(width, height)
+	sx := float64(p.width) / float64(width)
+	sy := float64(p.height) / float64(height)
+	for y := 0; y < height; y++ {
+		y0, y1 := span(y, sy, p.height)
+		for x := 0; x < width; x++ {
+			x0, x1 := span(x, sx, p.width)
+			var sum float32
+			n := 0
+			for yy := y0; yy < y1; yy++ {
+				for xx := x0; xx < x1; xx++ {
+					v := p.at(xx, yy)
+					if !math.IsNaN(float64(v)) {
+						sum += v
+						n++
+					}
+				}
+			}
+			if n == 0 {
+				out.values[y*width+x] = float32(math.NaN())
+			} else {
+				out.values[y*width+x] = sum / float32(n)
+			}
+		}
+	}
+	return out
+}
+
+// span returns the source pixels [start, end) covered by the destination pixel i at scale s
+func span(i int, s float64, size int) (int, int) {
+	start := int(float64(i) * s)
+	end := max(int(float64(i+1)*s), start+1)
+	return min(start, size-1), min(end, size)
+}
+
+// decodeAlbedo decodes the segments of a visible band into a plane of albedo, missing segments are NaN
+func decodeAlbedo(ctx context.Context, s segments, opts Options) (*plane, *HMFile, []int, error) {
+	return decodePlane(ctx, s, opts, false, (*HMFile).Albedo)
+}
+
+// decodeBrightnessTemperature decodes the segments of an infrared band into a plane of brightness temperature
+func decodeBrightnessTemperature(ctx context.Context, s segments, opts Options) (*plane, *HMFile, []int, error) {
+	return decodePlane(ctx, s, opts, true, (*HMFile).BrightnessTemperature)
+}
+
+// decodePlane decodes the segments of a band into a plane of calibrated values, missing segments are NaN
+// The band is checked on the header of the first segment, before the plane is allocated or any pixel decoded
+func decodePlane(ctx context.Context, s segments, opts Options, infrared bool, calibrate func(h *HMFile, count uint16) float64) (*plane, *HMFile, []int, error) {
+	var p *plane
+	header, missing, err := decodeSegments(ctx, s, opts, func(h *HMFile, width, height int) error {
+		if h.IsInfrared() != infrared {
+			kind := "a visible"
+			if infrared {
+				kind = "an infrared"
+			}
+			return fmt.Errorf("band %d isn't %s band", h.CalibrationInfo.BandNumber, kind)
+		}
+		p = newPlane(width, height)
+		p.segments = make([]*HMFile, h.SegmentInfo.SegmentTotalNumber)
+		return nil
+	}, func(h *HMFile, x, y int, count uint16) {
+		// Every segment is decoded by a single goroutine, which is the only one setting its header
+		if i := int(h.SegmentInfo.SegmentSequenceNumber) - 1; p.segments[i] == nil {
+			p.segments[i] = h
+		}
+		p.values[y*p.width+x] = float32(calibrate(h, count))
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, segment := range missing {
+		y0, y1 := segmentLines(p.height, int(header.SegmentInfo.SegmentTotalNumber), segment)
+		for i := y0 * p.width; i < y1*p.width; i++ {
+			p.values[i] = float32(math.NaN())
+		}
+	}
+	return p, header, missing, nil
+}
+
+// composite holds the red, band 2 and blue albedos of a true colour full disk on the grid of the blue band
+type composite struct {
+	r, g, b *plane
+	header  *HMFile
+	missing []int
+}
+
+// segmentHeader returns the header of the blue segment holding a line of the composite, the first header when it is
+// missing
+func (c *composite) segmentHeader(y int) *HMFile {
+	segments := c.b.segments
+	if h := segments[y*len(segments)/c.b.height]; h != nil {
+		return h
+	}
+	return c.header
+}
+
+// at returns the true colour of a pixel
+func (c *composite) at(x, y int) color.RGBA {
+	return trueColorPixel(c.r.at(x, y), c.g.at(x, y), c.b.at(x, y))
+}
+
+// TrueColor decodes the segments of bands 1 (blue), 2 (green) and 3 (red) into a true colour full disk
+// opts.Downsample is relative to the 1km bands, band 3 is decimated twice as much so the bands match
+// Missing segments of any band are missing in the composite
+func TrueColor(ctx context.Context, blue, green, red []io.ReadCloser, opts Options) (*Result, error) {
+	for _, sections := range [][]io.ReadCloser{blue, green, red} {
+		defer closeSections(sections)
+	}
+	return trueColor(ctx, sectionSegments(blue), sectionSegments(green), sectionSegments(red), opts)
+}
+
+// TrueColorFullDisk is TrueColor opening the segments of the bands like DecodeFullDisk
+func TrueColorFullDisk(ctx context.Context, blue, green, red SegmentOpener, opts Options) (*Result, error) {
+	return trueColor(ctx, fullDiskSegments(blue), fullDiskSegments(green), fullDiskSegments(red), opts)
+}
+
+func trueColor(ctx context.Context, blue, green, red segments, opts Options) (*Result, error) {
+	c, err := decodeComposite(ctx, blue, green, red, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, c.b.width, c.b.height))
+	for y := 0; y < c.b.height; y++ {
+		for x := 0; x < c.b.width; x++ {
+			img.SetRGBA(x, y, c.at(x, y))
+		}
+	}
+	fillMissing(img, int(c.header.SegmentInfo.SegmentTotalNumber), c.missing, opts.NoData)
+
+	return &Result{Image: img, Header: c.header, Missing: c.missing}, nil
+}
+
+// decodeComposite decodes bands 1, 2 and 3 concurrently, resampling them to the grid of band 1
+func decodeComposite(ctx context.Context, blue, green, red segments, opts Options) (*composite, error) {
+	downsample := max(opts.Downsample, 1)
+	bands := []struct {
+		name       string
+		segments   segments
+		downsample int
+		plane      *plane
+		header     *HMFile
+		missing    []int
+	}{
+		{name: "blue", segments: blue, downsample: downsample},
+		{name: "green", segments: green, downsample: downsample},
+		{name: "red", segments: red, downsample: downsample * DiskSize(3) / DiskSize(1)},
+	}
+	group, ctx := errgroup.WithContext(ctx)
+	for i := range bands {
+		b := &bands[i]
+		bandOpts := opts
+		bandOpts.Downsample = b.downsample
+		group.Go(func() error {
+			var err error
+			b.plane, b.header, b.missing, err = decodeAlbedo(ctx, b.segments, bandOpts)
+			if err != nil {
+				return fmt.Errorf("failed to decode %s band: %w", b.name, err)
+			}
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+
+	// Resample everything to the grid of the blue band
+	width, height := bands[0].plane.width, bands[0].plane.height
+	c := &composite{
+		r:      bands[2].plane.resample(width, height),
+		g:      bands[1].plane.resample(width, height),
+		b:      bands[0].plane,
+		header: bands[0].header,
+	}
+	for _, band := range bands {
+		c.missing = append(c.missing, band.missing...)
+	}
+	slices.Sort(c.missing)
+	c.missing = slices.Compact(c.missing)
+	return c, nil
+}
+
+// trueColorPixel maps red, band 2 and blue albedos into a colour, black if any band has no data
+func trueColorPixel(r, g, b float32) color.RGBA {
+	if math.IsNaN(float64(r)) || math.IsNaN(float64(g)) || math.IsNaN(float64(b)) {
+		return color.RGBA{A: 255}
+	}
+	green := (1-GreenBlend)*g + GreenBlend*r
+	return color.RGBA{R: reflectance(r), G: reflectance(green), B: reflectance(b), A: 255}
+}
+
+// reflectance maps an albedo into 0-255 with a gamma of 2 so the dimmer surfaces are visible
+func reflectance(albedo float32) uint8 {
+	v := math.Sqrt(math.Max(0, math.Min(float64(albedo), 1)))
+	return uint8(math.Round(v * 255))
+}
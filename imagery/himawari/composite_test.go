@@ -0,0 +1,105 @@
+package himawari
+
+import (
+	"context"
+	"encoding/binary"
+	"image/color"
+	"io"
+	"math"
+	"testing"
+)
+
+func TestPlaneResample(t *testing.T) {
+	nan := float32(math.NaN())
+	p := &plane{width: 4, height: 2, values: []float32{
+		1, 3, 0, nan,
+		1, 3, nan, nan,
+	}}
+	got := p.resample(2, 1)
+	if got.width != 2 || got.height != 1 {
+		t.Fatalf("expected a 2x1 plane but got %dx%d", got.width, got.height)
+	}
+	if got.at(0, 0) != 2 {
+		t.Errorf("expected the average 2 but got %f", got.at(0, 0))
+	}
+	if got.at(1, 0) != 0 {
+		t.Errorf("expected no data pixels to be ignored but got %f", got.at(1, 0))
+	}
+
+	// All no data stays no data
+	p.values[2] = nan
+	if v := p.resample(2, 1).at(1, 0); !math.IsNaN(float64(v)) {
+		t.Errorf("expected NaN but got %f", v)
+	}
+
+	// Enlarging repeats pixels
+	got = (&plane{width: 1, height: 1, values: []float32{0.5}}).resample(3, 2)
+	for _, v := range got.values {
+		if v != 0.5 {
+			t.Errorf("expected 0.5 on every enlarged pixel but got %v", got.values)
+			break
+		}
+	}
+}
+
+func TestTrueColorPixel(t *testing.T) {
+	if c := trueColorPixel(1, 1, 1); c != (color.RGBA{R: 255, G: 255, B: 255, A: 255}) {
+		t.Errorf("expected clouds to be white but got %v", c)
+	}
+	if c := trueColorPixel(0, 0, float32(math.NaN())); c != (color.RGBA{A: 255}) {
+		t.Errorf("expected no data to be black but got %v", c)
+	}
+	// Vegetation is brighter in red than band 2, the synthetic green pulls some of it
+	c := trueColorPixel(0.2, 0.1, 0.05)
+	if c.G <= reflectance(0.1) || c.R <= c.G || c.G <= c.B {
+		t.Errorf("unexpected synthetic green %v", c)
+	}
+}
+
+// readCounter records how many bytes of a segment were read
+type readCounter struct {
+	io.ReadCloser
+	n int
+}
+
+func (r *readCounter) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += n
+	return n, err
+}
+
+func TestDecodePlaneWrongBand(t *testing.T) {
+	tests := []struct {
+		name   string
+		band   uint16
+		decode func(ctx context.Context, s segments, opts Options) (*plane, *HMFile, []int, error)
+	}{
+		{name: "albedo of an infrared band", band: 13, decode: decodeAlbedo},
+		{name: "brightness temperature of a visible band", band: 3, decode: decodeBrightnessTemperature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			disk := testDisk(t, binary.LittleEndian, tt.band, 400, 4)
+			counters := make([]*readCounter, len(disk))
+			sections := make([]io.ReadCloser, len(disk))
+			for i, s := range disk {
+				counters[i] = &readCounter{ReadCloser: s}
+				sections[i] = counters[i]
+			}
+			if _, _, _, err := tt.decode(context.Background(), sectionSegments(sections), Options{}); err == nil {
+				t.Fatalf("expected band %d to be rejected", tt.band)
+			}
+
+			// Only the header of the first segment is read, the 80000 bytes of pixels aren't
+			header := len(encodeTest(t, testFile(binary.LittleEndian, tt.band, 400, 100, 1, 4), testPixels(400*100))) - 2*400*100
+			if counters[0].n > header+4096 {
+				t.Errorf("expected only the %d bytes header of segment 1 to be read but got %d bytes", header, counters[0].n)
+			}
+			for i, c := range counters[1:] {
+				if c.n != 0 {
+					t.Errorf("expected segment %d not to be read but got %d bytes", i+2, c.n)
+				}
+			}
+		})
+	}
+}
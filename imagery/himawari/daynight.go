@@ -0,0 +1,107 @@
+package himawari
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/sync/errgroup"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"slices"
+)
+
+const (
+	// DayZenith is the solar zenith angle in degrees up to which only the visible composite is shown
+	DayZenith = 75
+	// NightZenith is the solar zenith angle in degrees from which only the infrared band is shown, in between both
+	// are blended
+	NightZenith = 90
+)
+
+const (
+	// nightColdest is the brightness temperature [K] shown as white by PaletteGrayscaleInverted, high cloud tops
+	nightColdest = 200
+	// nightWarmest is the brightness temperature [K] shown as black by PaletteGrayscaleInverted, warm surfaces
+	nightWarmest = 310
+)
+
+// DayNight decodes a true colour full disk from bands 1, 2 and 3 on the day side, blended into the infrared band on
+// the night side by solar zenith angle, so the disk is never half black
+// opts.Downsample is relative to the 1km bands, the infrared band is usually band 13 (10.4μm) coloured by opts.Palette
+func DayNight(ctx context.Context, blue, green, red, infrared []io.ReadCloser, opts Options) (*Result, error) {
+	for _, sections := range [][]io.ReadCloser{blue, green, red, infrared} {
+		defer closeSections(sections)
+	}
+	return dayNight(ctx, sectionSegments(blue), sectionSegments(green), sectionSegments(red), sectionSegments(infrared), opts)
+}
+
+// DayNightFullDisk is DayNight opening the segments of the bands like DecodeFullDisk
+func DayNightFullDisk(ctx context.Context, blue, green, red, infrared SegmentOpener, opts Options) (*Result, error) {
+	return dayNight(ctx, fullDiskSegments(blue), fullDiskSegments(green), fullDiskSegments(red), fullDiskSegments(infrared), opts)
+}
+
+func dayNight(ctx context.Context, blue, green, red, infrared segments, opts Options) (*Result, error) {
+	var c *composite
+	var ir *plane
+	var irMissing []int
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.Go(func() error {
+		var err error
+		c, err = decodeComposite(groupCtx, blue, green, red, opts)
+		return err
+	})
+	group.Go(func() error {
+		irOpts := opts
+		// Infrared bands are 2km, decode them at the full resolution when the 1km bands aren't decimated enough
+		irOpts.Downsample = max(max(opts.Downsample, 1)*DiskSize(13)/DiskSize(1), 1)
+		var err error
+		ir, _, irMissing, err = decodeBrightnessTemperature(groupCtx, infrared, irOpts)
+		if err != nil {
+			return fmt.Errorf("failed to decode infrared band: %w", err)
+		}
+		return nil
+	})
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+
+	width, height := c.b.width, c.b.height
+	ir = ir.resample(width, height)
+	sunLat, sunLon := c.header.NavigationInfo.SubSolarPoint()
+	// Pixels are at the center of the full disk area they cover
+	scale := float64(c.header.DataInfo.NumberOfColumns) / float64(width)
+	palette := opts.palette()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		line := (float64(y)+0.5)*scale + 0.5
+		// Navigation corrections are per segment
+		h := c.segmentHeader(y)
+		for x := 0; x < width; x++ {
+			column := (float64(x)+0.5)*scale + 0.5
+			lat, lon, ok := h.LatLon(column, line)
+			if !ok {
+				img.SetRGBA(x, y, color.RGBA{A: 255})
+				continue
+			}
+			w := dayWeight(SolarZenith(lat, lon, sunLat, sunLon))
+			img.SetRGBA(x, y, blend(c.at(x, y), palette.Color(float64(ir.at(x, y))), w))
+		}
+	}
+
+	missing := append(slices.Clone(c.missing), irMissing...)
+	slices.Sort(missing)
+	missing = slices.Compact(missing)
+	fillMissing(img, int(c.header.SegmentInfo.SegmentTotalNumber), missing, opts.NoData)
+
+	return &Result{Image: img, Header: c.header, Missing: missing}, nil
+}
+
+// dayWeight returns how much of the day side is shown at a solar zenith angle, 1 is day and 0 is night
+func dayWeight(zenith float64) float64 {
+	return math.Max(0, math.Min((NightZenith-zenith)/(NightZenith-DayZenith), 1))
+}
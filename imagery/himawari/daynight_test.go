@@ -0,0 +1,127 @@
+package himawari
+
+import (
+	"context"
+	"encoding/binary"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestDayWeight(t *testing.T) {
+	tests := []struct {
+		zenith float64
+		want   float64
+	}{
+		{zenith: 0, want: 1},
+		{zenith: DayZenith, want: 1},
+		{zenith: (DayZenith + NightZenith) / 2.0, want: 0.5},
+		{zenith: NightZenith, want: 0},
+		{zenith: 180, want: 0},
+	}
+	for _, tt := range tests {
+		if got := dayWeight(tt.zenith); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("expected weight %f at zenith %f but got %f", tt.want, tt.zenith, got)
+		}
+	}
+}
+
+// sunAbove returns a header modifier placing the Sun over a longitude of the equator
+func sunAbove(lon float64) func(f *HMFile) {
+	return func(f *HMFile) {
+		f.NavigationInfo.NavigationTime = f.BasicInfo.ObservationStartTime
+		ra := degToRad(lon + siderealTime(f.NavigationInfo.NavigationTime))
+		f.NavigationInfo.SunPosition = Position{X: 1.5e8 * math.Cos(ra), Y: 1.5e8 * math.Sin(ra)}
+	}
+}
+
+func TestDayNight(t *testing.T) {
+	// 40 pixels 1km disk, 20 pixels 2km infrared disk, split in 4 segments
+	decode := func(sunLon float64) *Result {
+		res, err := DayNight(context.Background(),
+			testDisk(t, binary.LittleEndian, 1, 40, 4, sunAbove(sunLon)),
+			testDisk(t, binary.LittleEndian, 2, 40, 4),
+			testDisk(t, binary.LittleEndian, 3, 80, 4),
+			testDisk(t, binary.LittleEndian, 13, 20, 4),
+			Options{},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := res.Image.Bounds(); b.Dx() != 40 || b.Dy() != 40 {
+			t.Fatalf("expected a 40x40 image but got %s", b)
+		}
+		return res
+	}
+
+	// Sun over the sub satellite point, the center is the true colour composite
+	day := decode(140.7)
+	trueColor, err := TrueColor(context.Background(),
+		testDisk(t, binary.LittleEndian, 1, 40, 4),
+		testDisk(t, binary.LittleEndian, 2, 40, 4),
+		testDisk(t, binary.LittleEndian, 3, 80, 4),
+		Options{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := day.Image.RGBAAt(20, 20), trueColor.Image.RGBAAt(20, 20); got != want {
+		t.Errorf("expected the day side to be %v but got %v", want, got)
+	}
+
+	// Sun on the other side of the Earth, the center is the infrared band
+	night := decode(140.7 - 180)
+	// Pixel 20,20 is pixel 10,10 of the infrared disk, which is line 0 of segment 3 with count 10
+	want := PaletteGrayscaleInverted.Color(float64(float32(infraredFile().BrightnessTemperature(10))))
+	if got := night.Image.RGBAAt(20, 20); got != want {
+		t.Errorf("expected the night side to be %v but got %v", want, got)
+	}
+
+	// Space is black
+	if got := night.Image.RGBAAt(0, 0); got != (color.RGBA{A: 255}) {
+		t.Errorf("expected space to be black but got %v", got)
+	}
+}
+
+func TestDayNightNavigationCorrection(t *testing.T) {
+	// Segment 2 of the blue band (lines 11 to 20) is shifted 10 columns left, off the Earth near its western edge
+	shifted := func(f *HMFile) {
+		if f.SegmentInfo.SegmentSequenceNumber != 2 {
+			return
+		}
+		f.NavigationCorrectionInfo.NumberOfCorrectionInfo = 2
+		f.NavigationCorrectionInfo.Corrections = []NavigationCorrection{
+			{LineNumberAfterRotation: 11, ShiftAmountForColumnCorrection: -10},
+			{LineNumberAfterRotation: 20, ShiftAmountForColumnCorrection: -10},
+		}
+	}
+	decode := func(modify ...func(f *HMFile)) *Result {
+		res, err := DayNight(context.Background(),
+			testDisk(t, binary.LittleEndian, 1, 40, 4, append(modify, sunAbove(140.7))...),
+			testDisk(t, binary.LittleEndian, 2, 40, 4),
+			testDisk(t, binary.LittleEndian, 3, 80, 4),
+			testDisk(t, binary.LittleEndian, 13, 20, 4),
+			Options{},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	space := color.RGBA{A: 255}
+	uncorrected := decode()
+	if got := uncorrected.Image.RGBAAt(4, 15); got == space {
+		t.Fatalf("expected pixel 4,15 to be on the Earth without corrections")
+	}
+	res := decode(shifted)
+	if got := res.Image.RGBAAt(4, 15); got != space {
+		t.Errorf("expected the corrected pixel 4,15 of segment 2 to be space but got %v", got)
+	}
+	// Segment 1 has no corrections
+	for x := 0; x < 40; x++ {
+		if got, want := res.Image.RGBAAt(x, 5), uncorrected.Image.RGBAAt(x, 5); got != want {
+			t.Errorf("expected pixel %d,5 of segment 1 to be %v but got %v", x, want, got)
+		}
+	}
+}
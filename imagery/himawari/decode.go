@@ -1,9 +1,11 @@
-package main
+package himawari
 
 import (
-	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"strings"
 )
 
 const (
@@ -23,12 +25,15 @@ type HMFile struct {
 	ObservationTimeInfo      ObservationTimeInformationBlock
 	ErrorInfo                ErrorInformationBlock
 	SpareInfo                SpareInformationBlock
-	ImageData                io.ReadSeeker
-	cache                    io.Reader
-	readCount                int
-	totalReadPixels          int
-	lastSize                 int
-	bufferSize               int
+	// ImageData is positioned after the header, SeekLine and ReadRegion can move backwards when it is an io.Seeker
+	// positioned at the start of the segment file
+	ImageData io.Reader
+	// buf holds image data read ahead, buf[start:end] is not consumed yet
+	buf             []byte
+	start           int
+	end             int
+	totalReadPixels int
+	bufferSize      int
 }
 
 type Position struct {
@@ -119,6 +124,9 @@ type CalibrationInformationBlock struct {
 	Visible                           VisibleBand
 }
 
+// InfraredBand calibration of bands 7 to 16
+// BrightnessTemp, BrightnessC1 and BrightnessC2 are the c0, c1 and c2 coefficients to correct the effective brightness
+// temperature, Radiance, RadianceC1 and RadianceC2 are the inverse ones, to convert a brightness temperature into radiance
 type InfraredBand struct {
 	BrightnessTemp    float64
 	BrightnessC1      float64
@@ -132,6 +140,7 @@ type InfraredBand struct {
 	Spare             [40]byte
 }
 
+// VisibleBand calibration of bands 1 to 6, Albedo is the coefficient to convert radiance into albedo
 type VisibleBand struct {
 	Albedo              float64
 	UpdateTime          float64
@@ -215,198 +224,235 @@ type SpareInformationBlock struct {
 	Spare       [256]byte
 }
 
-func DecodeFile(r io.ReadSeeker) (*HMFile, error) {
+// DecodeFile decodes the header of a segment, leaving the reader positioned at the image data
+// r is only read forward, so streams such as bzip2.NewReader can be decoded directly
+// Every block is checked against the spec, see the errors in errors.go
+func DecodeFile(r io.Reader) (*HMFile, error) {
 	// Decode basic info
-	// uint8+uint16+uint16=5
-	basicInfo := make([]byte, 5)
-	_, err := r.Read(basicInfo)
+	// uint8+uint16+uint16+uint8=6, the byte order is needed before decoding the first fields
+	basicInfo := make([]byte, 6)
+	_, err := io.ReadFull(r, basicInfo)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: block 1: %s", ErrTruncatedHeader, err)
 	}
-	basicBuffer := bytes.NewBuffer(basicInfo)
 	i := BasicInformation{}
 	// Detect byte order
-	var byteOrderNum uint8
-	read(r, binary.BigEndian, &byteOrderNum)
 	var o binary.ByteOrder
-	if byteOrderNum == LittleEndian {
+	switch basicInfo[5] {
+	case LittleEndian:
 		o = binary.LittleEndian
-	} else {
+	case BigEndian:
 		o = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrInvalidByteOrder, basicInfo[5])
 	}
 	i.ByteOrder = o
 	// Read existing buffer
-	read(basicBuffer, o, &i.BlockNumber)
-	read(basicBuffer, o, &i.BlockLength)
-	read(basicBuffer, o, &i.TotalHeaderBlocks)
+	i.BlockNumber = basicInfo[0]
+	i.BlockLength = o.Uint16(basicInfo[1:3])
+	i.TotalHeaderBlocks = o.Uint16(basicInfo[3:5])
+	if i.BlockNumber != 1 {
+		return nil, &BlockNumberError{Expected: 1, Got: i.BlockNumber}
+	}
+	dec := &headerDecoder{r: r, o: o, block: 1, read: len(basicInfo)}
 
 	// Skip Byte order because already read and continue normal decoding
-	read(r, o, &i.Satellite)
-	read(r, o, &i.ProcessingCenter)
-	read(r, o, &i.ObservationArea)
-	read(r, o, &i.ObservationAreaInfo)
-	read(r, o, &i.ObservationTimeline)
-	read(r, o, &i.ObservationStartTime)
-	read(r, o, &i.ObservationEndTime)
-	read(r, o, &i.FileCreationTime)
-	read(r, o, &i.TotalHeaderLength)
-	read(r, o, &i.TotalDataLength)
-	read(r, o, &i.QualityFlag1)
-	read(r, o, &i.QualityFlag2)
-	read(r, o, &i.QualityFlag3)
-	read(r, o, &i.QualityFlag4)
-	read(r, o, &i.FileFormatVersion)
-	read(r, o, &i.FileName)
-	read(r, o, &i.Spare)
+	dec.decode(&i.Satellite)
+	dec.decode(&i.ProcessingCenter)
+	dec.decode(&i.ObservationArea)
+	dec.decode(&i.ObservationAreaInfo)
+	dec.decode(&i.ObservationTimeline)
+	dec.decode(&i.ObservationStartTime)
+	dec.decode(&i.ObservationEndTime)
+	dec.decode(&i.FileCreationTime)
+	dec.decode(&i.TotalHeaderLength)
+	dec.decode(&i.TotalDataLength)
+	dec.decode(&i.QualityFlag1)
+	dec.decode(&i.QualityFlag2)
+	dec.decode(&i.QualityFlag3)
+	dec.decode(&i.QualityFlag4)
+	dec.decode(&i.FileFormatVersion)
+	dec.decode(&i.FileName)
+	dec.decode(&i.Spare)
+	dec.end(uint32(i.BlockLength))
+	if dec.err == nil && !supportedVersion(i.Version()) {
+		return nil, &VersionError{Version: i.Version()}
+	}
 
 	// Decode data information block
 	d := DataInformationBlock{}
-	read(r, o, &d.BlockNumber)
-	read(r, o, &d.BlockLength)
-	read(r, o, &d.NumberOfBitsPerPixel)
-	read(r, o, &d.NumberOfColumns)
-	read(r, o, &d.NumberOfLines)
-	read(r, o, &d.CompressionFlag)
-	read(r, o, &d.Spare)
+	dec.begin(2, &d.BlockNumber)
+	dec.decode(&d.BlockLength)
+	dec.decode(&d.NumberOfBitsPerPixel)
+	dec.decode(&d.NumberOfColumns)
+	dec.decode(&d.NumberOfLines)
+	dec.decode(&d.CompressionFlag)
+	dec.decode(&d.Spare)
+	dec.end(uint32(d.BlockLength))
 
 	// Decode projection information block
 	p := ProjectionInformationBlock{}
-	read(r, o, &p.BlockNumber)
-	read(r, o, &p.BlockLength)
-	read(r, o, &p.SubLon)
-	read(r, o, &p.CFAC)
-	read(r, o, &p.LFAC)
-	read(r, o, &p.COFF)
-	read(r, o, &p.LOFF)
-	read(r, o, &p.DistanceFromEarthCenter)
-	read(r, o, &p.EarthEquatorialRadius)
-	read(r, o, &p.EarthPolarRadius)
-	read(r, o, &p.RatioDiff)
-	read(r, o, &p.RatioPolar)
-	read(r, o, &p.RatioEquatorial)
-	read(r, o, &p.SDCoefficient)
-	read(r, o, &p.ResamplingTypes)
-	read(r, o, &p.ResamplingSize)
-	read(r, o, &d.Spare)
+	dec.begin(3, &p.BlockNumber)
+	dec.decode(&p.BlockLength)
+	dec.decode(&p.SubLon)
+	dec.decode(&p.CFAC)
+	dec.decode(&p.LFAC)
+	dec.decode(&p.COFF)
+	dec.decode(&p.LOFF)
+	dec.decode(&p.DistanceFromEarthCenter)
+	dec.decode(&p.EarthEquatorialRadius)
+	dec.decode(&p.EarthPolarRadius)
+	dec.decode(&p.RatioDiff)
+	dec.decode(&p.RatioPolar)
+	dec.decode(&p.RatioEquatorial)
+	dec.decode(&p.SDCoefficient)
+	dec.decode(&p.ResamplingTypes)
+	dec.decode(&p.ResamplingSize)
+	dec.decode(&p.Spare)
+	dec.end(uint32(p.BlockLength))
 
 	// Decode navigation information block
 	n := NavigationInformationBlock{}
-	read(r, o, &n.BlockNumber)
-	read(r, o, &n.BlockLength)
-	read(r, o, &n.NavigationTime)
-	read(r, o, &n.SSPLongitude)
-	read(r, o, &n.SSPLatitude)
-	read(r, o, &n.DistanceFromEarthToSatellite)
-	read(r, o, &n.NadirLongitude)
-	read(r, o, &n.NadirLatitude)
-	read(r, o, &n.SunPosition.X)
-	read(r, o, &n.SunPosition.Y)
-	read(r, o, &n.SunPosition.Z)
-	read(r, o, &n.MoonPosition.X)
-	read(r, o, &n.MoonPosition.Y)
-	read(r, o, &n.MoonPosition.Z)
-	read(r, o, &n.Spare)
+	dec.begin(4, &n.BlockNumber)
+	dec.decode(&n.BlockLength)
+	dec.decode(&n.NavigationTime)
+	dec.decode(&n.SSPLongitude)
+	dec.decode(&n.SSPLatitude)
+	dec.decode(&n.DistanceFromEarthToSatellite)
+	dec.decode(&n.NadirLongitude)
+	dec.decode(&n.NadirLatitude)
+	dec.decode(&n.SunPosition.X)
+	dec.decode(&n.SunPosition.Y)
+	dec.decode(&n.SunPosition.Z)
+	dec.decode(&n.MoonPosition.X)
+	dec.decode(&n.MoonPosition.Y)
+	dec.decode(&n.MoonPosition.Z)
+	dec.decode(&n.Spare)
+	dec.end(uint32(n.BlockLength))
 
 	// Decode calibration info block
 	c := CalibrationInformationBlock{}
-	read(r, o, &c.BlockNumber)
-	read(r, o, &c.BlockLength)
-	read(r, o, &c.BandNumber)
-	read(r, o, &c.CentralWaveLength)
-	read(r, o, &c.ValidNumberOfBitsPerPixel)
-	read(r, o, &c.CountValueOfErrorPixels)
-	read(r, o, &c.CountValueOfPixelsOutsideScanArea)
-	read(r, o, &c.SlopeForCountRadianceEq)
-	read(r, o, &c.InterceptForCountRadianceEq)
+	dec.begin(5, &c.BlockNumber)
+	dec.decode(&c.BlockLength)
+	dec.decode(&c.BandNumber)
+	dec.decode(&c.CentralWaveLength)
+	dec.decode(&c.ValidNumberOfBitsPerPixel)
+	dec.decode(&c.CountValueOfErrorPixels)
+	dec.decode(&c.CountValueOfPixelsOutsideScanArea)
+	dec.decode(&c.SlopeForCountRadianceEq)
+	dec.decode(&c.InterceptForCountRadianceEq)
 	// Visible light
 	if c.BandNumber < 7 {
-		read(r, o, &c.Visible.Albedo)
-		read(r, o, &c.Visible.UpdateTime)
-		read(r, o, &c.Visible.CalibratedSlope)
-		read(r, o, &c.Visible.CalibratedIntercept)
-		read(r, o, &c.Visible.Spare)
+		dec.decode(&c.Visible.Albedo)
+		dec.decode(&c.Visible.UpdateTime)
+		dec.decode(&c.Visible.CalibratedSlope)
+		dec.decode(&c.Visible.CalibratedIntercept)
+		dec.decode(&c.Visible.Spare)
 	} else {
-		// TODO: infrared, 112 means what is the end of the block
-		read(r, o, make([]byte, 112))
+		// Infrared bands, from 7 to 16
+		dec.decode(&c.Infrared.BrightnessTemp)
+		dec.decode(&c.Infrared.BrightnessC1)
+		dec.decode(&c.Infrared.BrightnessC2)
+		dec.decode(&c.Infrared.Radiance)
+		dec.decode(&c.Infrared.RadianceC1)
+		dec.decode(&c.Infrared.RadianceC2)
+		dec.decode(&c.Infrared.SpeedOfLight)
+		dec.decode(&c.Infrared.PlanckConstant)
+		dec.decode(&c.Infrared.BoltzmannConstant)
+		dec.decode(&c.Infrared.Spare)
 	}
+	dec.end(uint32(c.BlockLength))
 
 	// Decode inter calibration info block
 	ci := InterCalibrationInformationBlock{}
-	read(r, o, &ci.BlockNumber)
-	read(r, o, &ci.BlockLength)
-	read(r, o, &ci.GSICSIntercept)
-	read(r, o, &ci.GSICSSlope)
-	read(r, o, &ci.GSICSQuadratic)
-	read(r, o, &ci.RadianceBias)
-	read(r, o, &ci.RadianceUncertainty)
-	read(r, o, &ci.RadianceStandardScene)
-	read(r, o, &ci.GSICSCorrectionStart)
-	read(r, o, &ci.GSICSCorrectionEnd)
-	read(r, o, &ci.GSICSCalibrationUpperLimit)
-	read(r, o, &ci.GSICSCalibrationLowerLimit)
-	read(r, o, &ci.GSICSFileName)
-	read(r, o, &ci.Spare)
+	dec.begin(6, &ci.BlockNumber)
+	dec.decode(&ci.BlockLength)
+	dec.decode(&ci.GSICSIntercept)
+	dec.decode(&ci.GSICSSlope)
+	dec.decode(&ci.GSICSQuadratic)
+	dec.decode(&ci.RadianceBias)
+	dec.decode(&ci.RadianceUncertainty)
+	dec.decode(&ci.RadianceStandardScene)
+	dec.decode(&ci.GSICSCorrectionStart)
+	dec.decode(&ci.GSICSCorrectionEnd)
+	dec.decode(&ci.GSICSCalibrationUpperLimit)
+	dec.decode(&ci.GSICSCalibrationLowerLimit)
+	dec.decode(&ci.GSICSFileName)
+	dec.decode(&ci.Spare)
+	dec.end(uint32(ci.BlockLength))
 
 	// Decode segment info block
 	s := SegmentInformationBlock{}
-	read(r, o, &s.BlockNumber)
-	read(r, o, &s.BlockLength)
-	read(r, o, &s.SegmentTotalNumber)
-	read(r, o, &s.SegmentSequenceNumber)
-	read(r, o, &s.FirstLineNumberOfImageSegment)
-	read(r, o, &s.Spare)
+	dec.begin(7, &s.BlockNumber)
+	dec.decode(&s.BlockLength)
+	dec.decode(&s.SegmentTotalNumber)
+	dec.decode(&s.SegmentSequenceNumber)
+	dec.decode(&s.FirstLineNumberOfImageSegment)
+	dec.decode(&s.Spare)
+	dec.end(uint32(s.BlockLength))
 
 	// Decode navigation correction block
 	nc := NavigationCorrectionInformationBlock{}
-	read(r, o, &nc.BlockNumber)
-	read(r, o, &nc.BlockLength)
-	read(r, o, &nc.CenterColumnOfRotation)
-	read(r, o, &nc.CenterLineOfRotation)
-	read(r, o, &nc.AmountOfRotationalCorrection)
-	read(r, o, &nc.NumberOfCorrectionInfo)
+	dec.begin(8, &nc.BlockNumber)
+	dec.decode(&nc.BlockLength)
+	dec.decode(&nc.CenterColumnOfRotation)
+	dec.decode(&nc.CenterLineOfRotation)
+	dec.decode(&nc.AmountOfRotationalCorrection)
+	dec.decode(&nc.NumberOfCorrectionInfo)
 	nc.Corrections = make([]NavigationCorrection, nc.NumberOfCorrectionInfo)
 	for i := uint16(0); i < nc.NumberOfCorrectionInfo; i++ {
 		correct := NavigationCorrection{}
-		read(r, o, &correct.LineNumberAfterRotation)
-		read(r, o, &correct.ShiftAmountForColumnCorrection)
-		read(r, o, &correct.ShiftAmountForLineCorrection)
+		dec.decode(&correct.LineNumberAfterRotation)
+		dec.decode(&correct.ShiftAmountForColumnCorrection)
+		dec.decode(&correct.ShiftAmountForLineCorrection)
 		nc.Corrections[i] = correct
 	}
-	read(r, o, &nc.Spare)
+	dec.decode(&nc.Spare)
+	dec.end(uint32(nc.BlockLength))
 
 	// Decode observation time block
 	ob := ObservationTimeInformationBlock{}
-	read(r, o, &ob.BlockNumber)
-	read(r, o, &ob.BlockLength)
-	read(r, o, &ob.NumberOfObservationTimes)
+	dec.begin(9, &ob.BlockNumber)
+	dec.decode(&ob.BlockLength)
+	dec.decode(&ob.NumberOfObservationTimes)
 	ob.Observations = make([]ObservationTime, ob.NumberOfObservationTimes)
 	for i := uint16(0); i < ob.NumberOfObservationTimes; i++ {
 		observation := ObservationTime{}
-		read(r, o, &observation.LineNumber)
-		read(r, o, &observation.ObservationTime)
+		dec.decode(&observation.LineNumber)
+		dec.decode(&observation.ObservationTime)
 		ob.Observations[i] = observation
 	}
-	read(r, o, &ob.Spare)
+	dec.decode(&ob.Spare)
+	dec.end(uint32(ob.BlockLength))
 
 	// Decode error information block
 	ei := ErrorInformationBlock{}
-	read(r, o, &ei.BlockNumber)
-	read(r, o, &ei.BlockLength)
-	read(r, o, &ei.NumberOfErrors)
+	dec.begin(10, &ei.BlockNumber)
+	dec.decode(&ei.BlockLength)
+	dec.decode(&ei.NumberOfErrors)
 	ei.Errors = make([]ErrorInformation, ei.NumberOfErrors)
 	for i := uint16(0); i < ei.NumberOfErrors; i++ {
 		errorInfo := ErrorInformation{}
-		read(r, o, &errorInfo.LineNumber)
-		read(r, o, &errorInfo.NumberOfPixels)
+		dec.decode(&errorInfo.LineNumber)
+		dec.decode(&errorInfo.NumberOfPixels)
 		ei.Errors[i] = errorInfo
 	}
-	read(r, o, &ei.Spare)
+	dec.decode(&ei.Spare)
+	dec.end(ei.BlockLength)
 
 	// Decode spare information block
 	sp := SpareInformationBlock{}
-	read(r, o, &sp.BlockNumber)
-	read(r, o, &sp.BlockLength)
-	read(r, o, &sp.Spare)
+	dec.begin(11, &sp.BlockNumber)
+	dec.decode(&sp.BlockLength)
+	dec.decode(&sp.Spare)
+	dec.end(uint32(sp.BlockLength))
+
+	// Newer versions may append data after the known blocks, image data starts after the header
+	dec.block = 0
+	dec.skipTo(i.TotalHeaderLength)
+	if dec.err != nil {
+		return nil, dec.err
+	}
 
 	// Decode data
 	h := &HMFile{
@@ -432,37 +478,187 @@ func DecodeFile(r io.ReadSeeker) (*HMFile, error) {
 	return h, nil
 }
 
-// read util function that reads and ignore error
-func read(f io.Reader, o binary.ByteOrder, dst any) {
-	_ = binary.Read(f, o, dst)
+// headerDecoder decodes header fields, keeping the first error so blocks can be decoded without checking every field
+type headerDecoder struct {
+	r io.Reader
+	o binary.ByteOrder
+	// block being decoded, used in errors
+	block uint8
+	// read bytes since the start of the file and of the current block
+	read       int
+	blockStart int
+	err        error
 }
 
-func (f *HMFile) ReadPixel() (uint16, error) {
-	f.updateCache()
+// decode reads a field into dst
+func (dec *headerDecoder) decode(dst any) {
+	if dec.err != nil {
+		return
+	}
+	err := binary.Read(dec.r, dec.o, dst)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		dec.err = fmt.Errorf("%w: block %d", ErrTruncatedHeader, dec.block)
+		return
+	} else if err != nil {
+		dec.err = fmt.Errorf("failed to decode block %d: %w", dec.block, err)
+		return
+	}
+	dec.read += binary.Size(dst)
+}
 
-	var pix uint16
-	err := binary.Read(f.cache, f.BasicInfo.ByteOrder, &pix)
+// begin starts a new block, checking its number
+func (dec *headerDecoder) begin(block uint8, dst *uint8) {
+	dec.block = block
+	dec.blockStart = dec.read
+	dec.decode(dst)
+	if dec.err == nil && *dst != block {
+		dec.err = &BlockNumberError{Expected: block, Got: *dst}
+	}
+}
+
+// end finishes a block, checking that its declared length is what the spec defines
+func (dec *headerDecoder) end(length uint32) {
+	if dec.err != nil {
+		return
+	}
+	if expected := uint32(dec.read - dec.blockStart); length != expected {
+		dec.err = &BlockLengthError{Block: dec.block, Expected: expected, Got: length}
+	}
+}
+
+// skipTo discards bytes until offset, failing if it was already read past it
+func (dec *headerDecoder) skipTo(offset uint32) {
+	if dec.err != nil {
+		return
+	}
+	if int(offset) < dec.read {
+		dec.err = &BlockLengthError{Block: 0, Expected: uint32(dec.read), Got: offset}
+		return
+	}
+	n, err := io.CopyN(io.Discard, dec.r, int64(offset)-int64(dec.read))
+	dec.read += int(n)
 	if err != nil {
-		return uint16(0), err
+		dec.err = fmt.Errorf("%w: %s", ErrTruncatedHeader, err)
 	}
-	if f.totalReadPixels >= int(f.DataInfo.NumberOfColumns)*int(f.DataInfo.NumberOfLines) {
+}
+
+// supportedVersion returns if the file format version can be decoded, only 1.x versions exists
+func supportedVersion(version string) bool {
+	return strings.HasPrefix(version, "1.")
+}
+
+// trimNul returns the string of a NUL padded byte array
+func trimNul(b []byte) string {
+	return strings.TrimRight(string(b), "\x00")
+}
+
+// ReadPixel reads the next pixel count, returning io.EOF after the last pixel of the segment
+func (f *HMFile) ReadPixel() (uint16, error) {
+	if f.totalReadPixels >= f.pixels() {
 		return uint16(0), io.EOF
 	}
+	if f.end-f.start < 2 {
+		if err := f.fill(); err != nil {
+			return uint16(0), err
+		}
+	}
 
-	f.readCount += 2
+	pix := f.BasicInfo.ByteOrder.Uint16(f.buf[f.start:])
+	f.start += 2
 	f.totalReadPixels += 1
 	return pix, nil
 }
 
-func (f *HMFile) updateCache() {
-	if f.readCount >= f.lastSize {
-		buffer := make([]byte, f.bufferSize)
-		// TODO: handle error
-		n, _ := f.ImageData.Read(buffer)
-		f.lastSize = n
-		f.readCount = 0
-		f.cache = bytes.NewReader(buffer)
+// ReadRow reads the next NumberOfColumns pixels, a whole line when the reads are aligned to lines, into row
+func (f *HMFile) ReadRow(row []uint16) error {
+	columns := int(f.DataInfo.NumberOfColumns)
+	if len(row) < columns {
+		return fmt.Errorf("row has %d pixels but lines have %d", len(row), columns)
 	}
+	n, err := f.ReadPixels(row[:columns])
+	if err == io.EOF && n > 0 {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// ReadLines reads as many whole lines as fit in dst, returning how many were read, and io.EOF after the last line
+func (f *HMFile) ReadLines(dst []uint16) (int, error) {
+	columns := int(f.DataInfo.NumberOfColumns)
+	if columns == 0 || len(dst) < columns {
+		return 0, fmt.Errorf("%d pixels can't hold a line of %d pixels", len(dst), columns)
+	}
+	n, err := f.ReadPixels(dst[:len(dst)/columns*columns])
+	if err == io.EOF && n%columns != 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n / columns, err
+}
+
+// ReadPixels reads up to len(dst) pixels, returning how many were read, and io.EOF if the segment ended before dst
+// was filled
+func (f *HMFile) ReadPixels(dst []uint16) (int, error) {
+	n := 0
+	for n < len(dst) {
+		remaining := f.pixels() - f.totalReadPixels
+		if remaining <= 0 {
+			return n, io.EOF
+		}
+		if f.end-f.start < 2 {
+			if err := f.fill(); err != nil {
+				return n, err
+			}
+		}
+		k := min(len(dst)-n, (f.end-f.start)/2, remaining)
+		decodePixels(f.BasicInfo.ByteOrder, dst[n:n+k], f.buf[f.start:f.start+2*k])
+		f.start += 2 * k
+		f.totalReadPixels += k
+		n += k
+	}
+	return n, nil
+}
+
+// decodePixels decodes src into dst, byte orders are inlined as this is the decoding hot path
+func decodePixels(o binary.ByteOrder, dst []uint16, src []byte) {
+	switch o {
+	case binary.LittleEndian:
+		for i := range dst {
+			dst[i] = uint16(src[2*i]) | uint16(src[2*i+1])<<8
+		}
+	case binary.BigEndian:
+		for i := range dst {
+			dst[i] = uint16(src[2*i])<<8 | uint16(src[2*i+1])
+		}
+	default:
+		for i := range dst {
+			dst[i] = o.Uint16(src[2*i:])
+		}
+	}
+}
+
+// pixels returns the amount of pixels in the segment
+func (f *HMFile) pixels() int {
+	return int(f.DataInfo.NumberOfColumns) * int(f.DataInfo.NumberOfLines)
+}
+
+// fill reads ahead as much image data as the buffer holds, keeping what wasn't consumed
+// Returns io.ErrUnexpectedEOF when not even a pixel is left, as the header declares more pixels
+func (f *HMFile) fill() error {
+	if f.buf == nil {
+		f.buf = make([]byte, max(f.bufferSize, 2))
+	}
+	// Streams may return short reads, fill the whole buffer so pixels never straddle two reads
+	f.end = copy(f.buf, f.buf[f.start:f.end])
+	f.start = 0
+	n, err := io.ReadFull(f.ImageData, f.buf[f.end:])
+	f.end += n
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	if f.end < 2 {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
 }
 
 // Skip Skips N pixels
@@ -471,21 +667,74 @@ func (f *HMFile) Skip(n int) error {
 	f.totalReadPixels += n
 	// We have 16 bytes per pixel, needs s*2 bytes
 	skipCount := 2 * n
-	// If we should skip the file or the cache
-	if f.bufferSize-f.readCount <= skipCount {
-		// Skip the skip count - what we already will skip from the cache
-		skip := skipCount - (f.bufferSize - f.readCount)
-		io.CopyN(io.Discard, f.ImageData, int64(skip))
-		// Update the cache
-		f.readCount += skipCount
-		f.updateCache()
-	} else {
-		_, err := io.CopyN(io.Discard, f.cache, int64(skipCount))
-		f.readCount += skipCount
+	// Skip from what is buffered first, then from the image data
+	buffered := f.end - f.start
+	if skipCount <= buffered {
+		f.start += skipCount
+		return nil
+	}
+	skipCount -= buffered
+	f.start = f.end
+	_, err := io.CopyN(io.Discard, f.ImageData, int64(skipCount))
+	if err == io.EOF && f.totalReadPixels <= f.pixels() {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// SeekLine positions the next read at the start of line n of the segment, starting at 0
+// Seekable image data jumps straight to the line, otherwise only forward seeks are possible
+func (f *HMFile) SeekLine(n int) error {
+	if n < 0 || n > int(f.DataInfo.NumberOfLines) {
+		return fmt.Errorf("line %d is outside the %d lines of the segment", n, f.DataInfo.NumberOfLines)
+	}
+	return f.seekPixel(n * int(f.DataInfo.NumberOfColumns))
+}
+
+// ReadRegion reads the width x height counts starting at column x and line y of the segment, line by line
+func (f *HMFile) ReadRegion(x, y, width, height int) ([]uint16, error) {
+	columns, lines := int(f.DataInfo.NumberOfColumns), int(f.DataInfo.NumberOfLines)
+	if x < 0 || y < 0 || width < 0 || height < 0 || x+width > columns || y+height > lines {
+		return nil, fmt.Errorf("region %dx%d at %d,%d is outside the %dx%d segment", width, height, x, y, columns, lines)
+	}
+	region := make([]uint16, width*height)
+	for line := 0; line < height; line++ {
+		err := f.seekPixel((y+line)*columns + x)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("failed to seek line %d: %w", y+line, err)
+		}
+		n, err := f.ReadPixels(region[line*width : (line+1)*width])
+		if err == io.EOF && n < width {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read line %d: %w", y+line, err)
 		}
 	}
+	return region, nil
+}
 
+// seekPixel positions the next read at pixel p, moving inside the buffer when it is already read ahead
+func (f *HMFile) seekPixel(p int) error {
+	delta := p - f.totalReadPixels
+	if delta >= 0 && 2*delta <= f.end-f.start {
+		f.start += 2 * delta
+		f.totalReadPixels = p
+		return nil
+	}
+	s, ok := f.ImageData.(io.Seeker)
+	if !ok {
+		if delta < 0 {
+			return ErrNotSeekable
+		}
+		return f.Skip(delta)
+	}
+	_, err := s.Seek(int64(f.BasicInfo.TotalHeaderLength)+2*int64(p), io.SeekStart)
+	if err != nil {
+		return err
+	}
+	// Whatever was read ahead belongs to another position
+	f.start, f.end = 0, 0
+	f.totalReadPixels = p
 	return nil
 }
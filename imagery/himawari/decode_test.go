@@ -1,22 +1,40 @@
-package main
+package himawari
 
 import (
+	"bytes"
+	"compress/bzip2"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"github.com/google/go-cmp/cmp"
 	"io"
 	"math"
 	"os"
+	"slices"
 	"strings"
 	"testing"
+	"testing/iotest"
 	"unicode"
 )
 
-func TestDecodeMetadata(t *testing.T) {
-	f, err := os.Open("test-data/HS_H09_20231031_1340_B02_FLDK_R10_S0110.DAT")
+// testDataPath is a real segment, too big for the repository, tests using it are skipped when it isn't downloaded
+const testDataPath = "test-data/HS_H09_20231031_1340_B02_FLDK_R10_S0110.DAT"
+
+// openTestData opens a test data file, closing it when the test ends
+func openTestData(tb testing.TB, name string) *os.File {
+	f, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		tb.Skipf("%s isn't available", name)
+	}
 	if err != nil {
-		t.Error(err)
+		tb.Fatal(err)
 	}
+	tb.Cleanup(func() { _ = f.Close() })
+	return f
+}
+
+func TestDecodeMetadata(t *testing.T) {
+	f := openTestData(t, testDataPath)
 	hw, err := DecodeFile(f)
 	if err != nil {
 		t.Error(err)
@@ -207,19 +225,77 @@ func TestDecodeMetadata(t *testing.T) {
 }
 
 func TestReadData(t *testing.T) {
-	//f, err := os.Open("test-data/HS_H09_20231031_1340_B02_FLDK_R10_S0110.DAT.bz2")
-	//if err != nil {
-	//	t.Error(err)
-	//}
-	//hw, err := DecodeFile(bzip2.NewReader(f))
-	//desiredCount := 11000 * 1100
+	f := openTestData(t, testDataPath+".bz2")
+	hw, err := DecodeFile(bzip2.NewReader(f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Mix reads and skips, the stream can only go forward
+	count := 0
+	desiredCount := 11000 * 1100
+	for {
+		_, err = hw.ReadPixel()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("failed to read pixel %d: %s", count, err)
+		}
+		count++
+		if count%1000 == 0 && count < desiredCount {
+			if err = hw.Skip(999); err != nil {
+				t.Fatalf("failed to skip at pixel %d: %s", count, err)
+			}
+			count += 999
+		}
+	}
+
+	if count != desiredCount {
+		t.Errorf("expected to read %d pixels but read %d", desiredCount, count)
+	}
 }
 
-func TestReadPixel(t *testing.T) {
-	f, err := os.Open("test-data/HS_H09_20231031_1340_B02_FLDK_R10_S0110.DAT")
+func TestReadCompressedStream(t *testing.T) {
+	// Synthetic 40x25 band 2 segment where every count is its index, compressed with bzip2 -9
+	f, err := os.Open("testdata/HS_H09_20231031_1340_B02_FLDK_R10_S0110_SYNTHETIC.DAT.bz2")
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
+	}
+	defer f.Close()
+	hw, err := DecodeFile(bzip2.NewReader(f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hw.DataInfo.NumberOfColumns != 40 || hw.DataInfo.NumberOfLines != 25 {
+		t.Fatalf("expected 40x25 pixels but got %dx%d", hw.DataInfo.NumberOfColumns, hw.DataInfo.NumberOfLines)
+	}
+	// Mix reads and skips, the stream can only go forward
+	const total = 40 * 25
+	count := 0
+	for {
+		px, err := hw.ReadPixel()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("failed to read pixel %d: %s", count, err)
+		}
+		if px != uint16(count) {
+			t.Fatalf("expected pixel %d to be %d but got %d", count, count, px)
+		}
+		count++
+		if count%100 == 0 && count < total {
+			if err = hw.Skip(37); err != nil {
+				t.Fatalf("failed to skip at pixel %d: %s", count, err)
+			}
+			count += 37
+		}
+	}
+	if count != total {
+		t.Errorf("expected to read %d pixels but read %d", total, count)
 	}
+}
+
+func TestReadPixel(t *testing.T) {
+	f := openTestData(t, testDataPath)
 	hw, err := DecodeFile(f)
 	px, _ := hw.ReadPixel()
 	if px != (hw.CalibrationInfo.CountValueOfPixelsOutsideScanArea) {
@@ -240,6 +316,331 @@ func TestReadPixel(t *testing.T) {
 	}
 }
 
+func TestReadRow(t *testing.T) {
+	f := openTestData(t, testDataPath)
+	hw, err := DecodeFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := make([]uint16, 11000)
+	lines := 0
+	for {
+		err = hw.ReadRow(row)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("failed to read line %d: %s", lines, err)
+		}
+		if row[0] != hw.CalibrationInfo.CountValueOfPixelsOutsideScanArea {
+			t.Errorf("expected first pixel of line %d to be outside the scan area but got %d", lines, row[0])
+		}
+		lines++
+	}
+
+	if lines != 1100 {
+		t.Errorf("expected to read %d lines but read %d", 1100, lines)
+	}
+}
+
+// basicBlock returns a little endian basic information block with the given length and version
+func basicBlock(length uint16, version string) []byte {
+	b := make([]byte, 282)
+	b[0] = 1
+	binary.LittleEndian.PutUint16(b[1:], length)
+	binary.LittleEndian.PutUint16(b[3:], 11)
+	b[5] = LittleEndian
+	// Version is after 5 + 1 + 16 + 16 + 4 + 2 + 2 + 8*3 + 4*2 + 4 bytes
+	copy(b[82:], version)
+	return b
+}
+
+func TestDecodeFileErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want func(err error) bool
+	}{
+		{
+			name: "empty",
+			data: []byte{},
+			want: func(err error) bool { return errors.Is(err, ErrTruncatedHeader) },
+		},
+		{
+			name: "truncated basic information",
+			data: basicBlock(282, "1.3")[:100],
+			want: func(err error) bool { return errors.Is(err, ErrTruncatedHeader) },
+		},
+		{
+			name: "truncated data information",
+			data: append(basicBlock(282, "1.3"), 2, 50, 0),
+			want: func(err error) bool { return errors.Is(err, ErrTruncatedHeader) },
+		},
+		{
+			name: "invalid byte order",
+			data: []byte{1, 26, 1, 11, 0, 7},
+			want: func(err error) bool { return errors.Is(err, ErrInvalidByteOrder) },
+		},
+		{
+			name: "unexpected first block",
+			data: []byte{2, 50, 0, 11, 0, 0},
+			want: func(err error) bool {
+				var e *BlockNumberError
+				return errors.As(err, &e) && e.Expected == 1 && e.Got == 2
+			},
+		},
+		{
+			name: "unexpected second block",
+			data: append(basicBlock(282, "1.3"), 3, 50, 0),
+			want: func(err error) bool {
+				var e *BlockNumberError
+				return errors.As(err, &e) && e.Expected == 2 && e.Got == 3
+			},
+		},
+		{
+			name: "block length mismatch",
+			data: basicBlock(283, "1.3"),
+			want: func(err error) bool {
+				var e *BlockLengthError
+				return errors.As(err, &e) && e.Block == 1 && e.Expected == 282 && e.Got == 283
+			},
+		},
+		{
+			name: "unsupported version",
+			data: basicBlock(282, "2.0"),
+			want: func(err error) bool {
+				var e *VersionError
+				return errors.As(err, &e) && e.Version == "2.0"
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hw, err := DecodeFile(bytes.NewReader(tt.data))
+			if hw != nil {
+				t.Errorf("expected no file to be decoded")
+			}
+			if !tt.want(err) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// gridCounts returns width x height counts where every count is line*100 + column
+func gridCounts(width, height int) []uint16 {
+	counts := make([]uint16, width*height)
+	for i := range counts {
+		counts[i] = uint16(i/width*100 + i%width)
+	}
+	return counts
+}
+
+func TestSeekLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		seekable bool
+		lines    []int
+		wantErr  error
+	}{
+		{name: "forward", seekable: true, lines: []int{2, 5, 9}},
+		{name: "backward", seekable: true, lines: []int{9, 0, 4, 4}},
+		{name: "forward stream", lines: []int{1, 3, 8}},
+		{name: "backward stream", lines: []int{5, 2}, wantErr: ErrNotSeekable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := memorySegment(30, 10, gridCounts(30, 10))
+			if !tt.seekable {
+				h.ImageData = struct{ io.Reader }{h.ImageData}
+			}
+			row := make([]uint16, 30)
+			for i, line := range tt.lines {
+				err := h.SeekLine(line)
+				if i == len(tt.lines)-1 && tt.wantErr != nil {
+					if !errors.Is(err, tt.wantErr) {
+						t.Fatalf("expected %v but got %v", tt.wantErr, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("failed to seek line %d: %s", line, err)
+				}
+				if err = h.ReadRow(row); err != nil {
+					t.Fatalf("failed to read line %d: %s", line, err)
+				}
+				if row[0] != uint16(line*100) || row[29] != uint16(line*100+29) {
+					t.Errorf("expected line %d but got %v", line, row)
+				}
+			}
+		})
+	}
+}
+
+func TestReadRegion(t *testing.T) {
+	h := memorySegment(30, 10, gridCounts(30, 10))
+	// Read the end of the segment first so the region has to seek backwards
+	if err := h.SeekLine(9); err != nil {
+		t.Fatal(err)
+	}
+	region, err := h.ReadRegion(27, 2, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint16{227, 228, 229, 327, 328, 329}
+	if !slices.Equal(region, want) {
+		t.Errorf("expected %v but got %v", want, region)
+	}
+	if _, err = h.ReadRegion(28, 0, 3, 1); err == nil {
+		t.Errorf("expected an error for a region outside the segment")
+	}
+}
+
+// byteOrders are the byte orders a segment can be encoded in
+var byteOrders = []binary.ByteOrder{binary.LittleEndian, binary.BigEndian}
+
+func TestReadByteOrders(t *testing.T) {
+	for _, o := range byteOrders {
+		for _, bufferSize := range []int{2, 6, 100, 20000} {
+			t.Run(fmt.Sprintf("%s_buffer_size_%d", o, bufferSize), func(t *testing.T) {
+				pixels := testPixels(37 * 11)
+				data := encodeTest(t, testFile(o, 13, 37, 11, 1, 1), pixels)
+				// Short reads make pixels straddle reads
+				hw, err := DecodeFile(iotest.HalfReader(bytes.NewReader(data)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if hw.BasicInfo.ByteOrder != o {
+					t.Fatalf("expected %s but got %s", o, hw.BasicInfo.ByteOrder)
+				}
+				hw.bufferSize = bufferSize
+
+				px, err := hw.ReadPixel()
+				if err != nil || px != pixels[0] {
+					t.Fatalf("expected first pixel %d but got %d: %v", pixels[0], px, err)
+				}
+				if err = hw.Skip(36); err != nil {
+					t.Fatal(err)
+				}
+				row := make([]uint16, 37)
+				if err = hw.ReadRow(row); err != nil || !slices.Equal(row, pixels[37:74]) {
+					t.Fatalf("expected line 1 %v but got %v: %v", pixels[37:74], row, err)
+				}
+				if err = hw.Skip(37*2 + 5); err != nil {
+					t.Fatal(err)
+				}
+				px, err = hw.ReadPixel()
+				if err != nil || px != pixels[37*4+5] {
+					t.Fatalf("expected pixel %d but got %d: %v", pixels[37*4+5], px, err)
+				}
+				n, err := hw.ReadPixels(row[:31])
+				if err != nil || n != 31 || !slices.Equal(row[:31], pixels[37*4+6:37*5]) {
+					t.Fatalf("expected the rest of line 4 but read %d: %v", n, err)
+				}
+				lines := make([]uint16, 37*3)
+				for _, want := range []int{3, 3, 0} {
+					n, err = hw.ReadLines(lines)
+					if want == 0 {
+						if n != 0 || err != io.EOF {
+							t.Fatalf("expected io.EOF after the last line but read %d: %v", n, err)
+						}
+						break
+					}
+					if err != nil || n != want {
+						t.Fatalf("expected %d lines but read %d: %v", want, n, err)
+					}
+				}
+				if _, err = hw.ReadPixel(); err != io.EOF {
+					t.Errorf("expected io.EOF after the last pixel but got %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestReadTruncatedByteOrders(t *testing.T) {
+	for _, o := range byteOrders {
+		t.Run(fmt.Sprint(o), func(t *testing.T) {
+			data := encodeTest(t, testFile(o, 2, 37, 11, 1, 1), testPixels(37*11))
+			hw, err := DecodeFile(bytes.NewReader(data[:len(data)-10]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, err := hw.ReadLines(make([]uint16, 37*11))
+			if err != io.ErrUnexpectedEOF {
+				t.Errorf("expected io.ErrUnexpectedEOF but read %d lines: %v", n, err)
+			}
+			hw, _ = DecodeFile(bytes.NewReader(data[:len(data)-10]))
+			if err = hw.Skip(37 * 11); err != io.ErrUnexpectedEOF {
+				t.Errorf("expected io.ErrUnexpectedEOF skipping the truncated data but got %v", err)
+			}
+		})
+	}
+}
+
+func TestSkipByteOrders(t *testing.T) {
+	const columns, lines, bufferSize = 50, 20, 64
+	total := columns * lines
+	for _, o := range byteOrders {
+		// One pixel past a full buffer, past the first line and up to the end
+		for _, skip := range []int{0, 1, bufferSize/2 + 1, columns + 3, total - 1, total} {
+			t.Run(fmt.Sprintf("%s_skip_%d", o, skip), func(t *testing.T) {
+				pixels := testPixels(total)
+				hw, err := DecodeFile(streamReader(encodeTest(t, testFile(o, 2, columns, lines, 1, 1), pixels)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				hw.bufferSize = bufferSize
+				if err = hw.Skip(skip); err != nil {
+					t.Fatalf("failed to skip %d: %s", skip, err)
+				}
+				count := 0
+				for {
+					px, err := hw.ReadPixel()
+					if err == io.EOF {
+						break
+					} else if err != nil {
+						t.Fatalf("failed to read pixel %d: %s", skip+count, err)
+					}
+					if px != pixels[skip+count] {
+						t.Fatalf("expected pixel %d to be %d but got %d", skip+count, pixels[skip+count], px)
+					}
+					count++
+				}
+				if count != total-skip {
+					t.Errorf("expected to read %d pixels but read %d", total-skip, count)
+				}
+			})
+		}
+	}
+}
+
+// streamReader returns a reader of data that isn't an io.Seeker, like a download
+func streamReader(data []byte) io.Reader {
+	return struct{ io.Reader }{bytes.NewReader(data)}
+}
+
+func TestSeekLineByteOrders(t *testing.T) {
+	for _, o := range byteOrders {
+		t.Run(fmt.Sprint(o), func(t *testing.T) {
+			pixels := testPixels(30 * 10)
+			hw, err := DecodeFile(bytes.NewReader(encodeTest(t, testFile(o, 2, 30, 10, 1, 1), pixels)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Image data starts after TotalHeaderLength bytes of the file
+			for _, line := range []int{7, 2} {
+				if err = hw.SeekLine(line); err != nil {
+					t.Fatal(err)
+				}
+				px, err := hw.ReadPixel()
+				if err != nil || px != pixels[line*30] {
+					t.Errorf("expected the first pixel of line %d to be %d but got %d: %v", line, pixels[line*30], px, err)
+				}
+			}
+		})
+	}
+}
+
 var table = []struct {
 	bufferSize int
 }{
@@ -254,10 +655,7 @@ var table = []struct {
 func BenchmarkHMFile_ReadPixel(b *testing.B) {
 	for _, v := range table {
 		b.Run(fmt.Sprintf("buffer_size_%d", v.bufferSize), func(b *testing.B) {
-			f, err := os.Open("test-data/HS_H09_20231031_1340_B02_FLDK_R10_S0110.DAT")
-			if err != nil {
-				b.Error(err)
-			}
+			f := openTestData(b, testDataPath)
 			hw, err := DecodeFile(f)
 			// Overwrite buffer size
 			hw.bufferSize = v.bufferSize
@@ -277,15 +675,31 @@ func BenchmarkHMFile_ReadPixel(b *testing.B) {
 				count++
 			}
 		})
+		// Same amount of pixels, decoded a line at a time
+		b.Run(fmt.Sprintf("row_buffer_size_%d", v.bufferSize), func(b *testing.B) {
+			f := openTestData(b, testDataPath)
+			hw, err := DecodeFile(f)
+			// Overwrite buffer size
+			hw.bufferSize = v.bufferSize
+			row := make([]uint16, hw.DataInfo.NumberOfColumns)
+			count := 0
+			for {
+				if count > b.N {
+					break
+				}
+				err = hw.ReadRow(row)
+				if err == io.EOF {
+					break
+				}
+				count += len(row)
+			}
+		})
 	}
 
 }
 
 func TestReadSkipSinglePixel(t *testing.T) {
-	f, err := os.Open("test-data/HS_H09_20231031_1340_B02_FLDK_R10_S0110.DAT")
-	if err != nil {
-		t.Error(err)
-	}
+	f := openTestData(t, testDataPath)
 	hw, err := DecodeFile(f)
 	totalPixels := 11000 * 1100
 	skip := totalPixels - 1
@@ -313,10 +727,7 @@ func TestReadSkipSinglePixel(t *testing.T) {
 }
 
 func TestReadSkipEntireFile(t *testing.T) {
-	f, err := os.Open("test-data/HS_H09_20231031_1340_B02_FLDK_R10_S0110.DAT")
-	if err != nil {
-		t.Error(err)
-	}
+	f := openTestData(t, testDataPath)
 	hw, err := DecodeFile(f)
 	totalPixels := 11000 * 1100
 	err = hw.Skip(totalPixels)
@@ -344,10 +755,7 @@ func FuzzHMFile_Skip(f *testing.F) {
 	f.Add(5)
 	f.Fuzz(func(t *testing.T, skip int) {
 		skip = int(math.Abs(float64(skip))) * 1000
-		f, err := os.Open("test-data/HS_H09_20231031_1340_B02_FLDK_R10_S0110.DAT")
-		if err != nil {
-			t.Error(err)
-		}
+		f := openTestData(t, testDataPath)
 		hw, err := DecodeFile(f)
 		totalPixels := 11000 * 1100
 		desiredCount := totalPixels - skip
@@ -0,0 +1,252 @@
+package himawari
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Header block lengths that don't depend on the amount of table entries
+const (
+	basicInformationLength      = 282
+	dataInformationLength       = 50
+	projectionInformationLength = 127
+	navigationInformationLength = 139
+	calibrationLength           = 147
+	interCalibrationLength      = 259
+	segmentInformationLength    = 47
+	spareInformationLength      = 259
+	totalHeaderBlocks           = 11
+)
+
+// NewSegment returns the header of a full disk segment of a band observed at start, filled with just what Encode and
+// Decode need, e.g. to build test segments. Counts use the bits per pixel of the band, the projection is the full
+// disk one and there is no GSICS correction period, the calibration coefficients are left to the caller
+func NewSegment(band, columns, lines, segment, total int, start time.Time) *HMFile {
+	start = start.UTC()
+	f := &HMFile{}
+	f.BasicInfo.ByteOrder = binary.LittleEndian
+	copy(f.BasicInfo.Satellite[:], "Himawari-9")
+	copy(f.BasicInfo.ProcessingCenter[:], "MSC")
+	copy(f.BasicInfo.ObservationArea[:], "FLDK")
+	copy(f.BasicInfo.FileFormatVersion[:], "1.3")
+	f.BasicInfo.ObservationTimeline = uint16(start.Hour()*100 + start.Minute())
+	f.BasicInfo.ObservationStartTime = MJD(start)
+	f.BasicInfo.ObservationEndTime = f.BasicInfo.ObservationStartTime
+	f.DataInfo.NumberOfColumns = uint16(columns)
+	f.DataInfo.NumberOfLines = uint16(lines)
+	f.ProjectionInfo = FullDiskProjection(band)
+	f.CalibrationInfo.BandNumber = uint16(band)
+	// Bands 1 to 6 have 11 bits, band 7 14 and the rest 12
+	switch {
+	case band <= 6:
+		f.CalibrationInfo.ValidNumberOfBitsPerPixel = 11
+	case band == 7:
+		f.CalibrationInfo.ValidNumberOfBitsPerPixel = 14
+	default:
+		f.CalibrationInfo.ValidNumberOfBitsPerPixel = 12
+	}
+	f.CalibrationInfo.CountValueOfErrorPixels = 65535
+	f.CalibrationInfo.CountValueOfPixelsOutsideScanArea = 65534
+	f.InterCalibrationInfo.GSICSCorrectionStart = InvalidValue
+	f.InterCalibrationInfo.GSICSCorrectionEnd = InvalidValue
+	f.SegmentInfo = SegmentInformationBlock{
+		SegmentTotalNumber:            uint8(total),
+		SegmentSequenceNumber:         uint8(segment),
+		FirstLineNumberOfImageSegment: uint16((segment-1)*lines + 1),
+	}
+	return f
+}
+
+// Encode writes f and its pixels as an HSD segment, pixels holds NumberOfColumns*NumberOfLines counts
+// Block numbers, block lengths, table sizes, header and data lengths are computed from f, so only the values need to
+// be filled, a nil ByteOrder writes a little endian segment
+func Encode(w io.Writer, f *HMFile, pixels []uint16) error {
+	columns, lines := int(f.DataInfo.NumberOfColumns), int(f.DataInfo.NumberOfLines)
+	if len(pixels) != columns*lines {
+		return fmt.Errorf("expected %dx%d pixels but got %d", columns, lines, len(pixels))
+	}
+
+	i := f.BasicInfo
+	var byteOrder uint8 = LittleEndian
+	o := i.ByteOrder
+	switch o {
+	case nil, binary.LittleEndian:
+		o = binary.LittleEndian
+	case binary.BigEndian:
+		byteOrder = BigEndian
+	default:
+		return fmt.Errorf("unsupported byte order %s", o)
+	}
+	nc := f.NavigationCorrectionInfo
+	ob := f.ObservationTimeInfo
+	ei := f.ErrorInfo
+	correctionLength := 21 + 10*len(nc.Corrections) + 40
+	observationLength := 5 + 10*len(ob.Observations) + 40
+	errorLength := 7 + 4*len(ei.Errors) + 40
+	headerLength := basicInformationLength + dataInformationLength + projectionInformationLength +
+		navigationInformationLength + calibrationLength + interCalibrationLength + segmentInformationLength +
+		correctionLength + observationLength + errorLength + spareInformationLength
+
+	enc := &headerEncoder{w: w, o: o}
+
+	// Basic information block
+	enc.encode(uint8(1))
+	enc.encode(uint16(basicInformationLength))
+	enc.encode(uint16(totalHeaderBlocks))
+	enc.encode(byteOrder)
+	enc.encode(i.Satellite)
+	enc.encode(i.ProcessingCenter)
+	enc.encode(i.ObservationArea)
+	enc.encode(i.ObservationAreaInfo)
+	enc.encode(i.ObservationTimeline)
+	enc.encode(i.ObservationStartTime)
+	enc.encode(i.ObservationEndTime)
+	enc.encode(i.FileCreationTime)
+	enc.encode(uint32(headerLength))
+	enc.encode(uint32(2 * len(pixels)))
+	enc.encode(i.QualityFlag1)
+	enc.encode(i.QualityFlag2)
+	enc.encode(i.QualityFlag3)
+	enc.encode(i.QualityFlag4)
+	enc.encode(i.FileFormatVersion)
+	enc.encode(i.FileName)
+	enc.encode(i.Spare)
+
+	// Data information block
+	d := f.DataInfo
+	enc.encode(uint8(2))
+	enc.encode(uint16(dataInformationLength))
+	enc.encode(uint16(16))
+	enc.encode(d.NumberOfColumns)
+	enc.encode(d.NumberOfLines)
+	enc.encode(d.CompressionFlag)
+	enc.encode(d.Spare)
+
+	// Projection information block
+	p := f.ProjectionInfo
+	enc.encode(uint8(3))
+	enc.encode(uint16(projectionInformationLength))
+	enc.encode(p.SubLon)
+	enc.encode(p.CFAC)
+	enc.encode(p.LFAC)
+	enc.encode(p.COFF)
+	enc.encode(p.LOFF)
+	enc.encode(p.DistanceFromEarthCenter)
+	enc.encode(p.EarthEquatorialRadius)
+	enc.encode(p.EarthPolarRadius)
+	enc.encode(p.RatioDiff)
+	enc.encode(p.RatioPolar)
+	enc.encode(p.RatioEquatorial)
+	enc.encode(p.SDCoefficient)
+	enc.encode(p.ResamplingTypes)
+	enc.encode(p.ResamplingSize)
+	enc.encode(p.Spare)
+
+	// Navigation information block
+	n := f.NavigationInfo
+	enc.encode(uint8(4))
+	enc.encode(uint16(navigationInformationLength))
+	enc.encode(n.NavigationTime)
+	enc.encode(n.SSPLongitude)
+	enc.encode(n.SSPLatitude)
+	enc.encode(n.DistanceFromEarthToSatellite)
+	enc.encode(n.NadirLongitude)
+	enc.encode(n.NadirLatitude)
+	enc.encode(n.SunPosition)
+	enc.encode(n.MoonPosition)
+	enc.encode(n.Spare)
+
+	// Calibration information block
+	c := f.CalibrationInfo
+	enc.encode(uint8(5))
+	enc.encode(uint16(calibrationLength))
+	enc.encode(c.BandNumber)
+	enc.encode(c.CentralWaveLength)
+	enc.encode(c.ValidNumberOfBitsPerPixel)
+	enc.encode(c.CountValueOfErrorPixels)
+	enc.encode(c.CountValueOfPixelsOutsideScanArea)
+	enc.encode(c.SlopeForCountRadianceEq)
+	enc.encode(c.InterceptForCountRadianceEq)
+	if f.IsInfrared() {
+		enc.encode(c.Infrared)
+	} else {
+		enc.encode(c.Visible)
+	}
+
+	// Inter calibration information block
+	ci := f.InterCalibrationInfo
+	enc.encode(uint8(6))
+	enc.encode(uint16(interCalibrationLength))
+	enc.encode(ci.GSICSIntercept)
+	enc.encode(ci.GSICSSlope)
+	enc.encode(ci.GSICSQuadratic)
+	enc.encode(ci.RadianceBias)
+	enc.encode(ci.RadianceUncertainty)
+	enc.encode(ci.RadianceStandardScene)
+	enc.encode(ci.GSICSCorrectionStart)
+	enc.encode(ci.GSICSCorrectionEnd)
+	enc.encode(ci.GSICSCalibrationUpperLimit)
+	enc.encode(ci.GSICSCalibrationLowerLimit)
+	enc.encode(ci.GSICSFileName)
+	enc.encode(ci.Spare)
+
+	// Segment information block
+	s := f.SegmentInfo
+	enc.encode(uint8(7))
+	enc.encode(uint16(segmentInformationLength))
+	enc.encode(s.SegmentTotalNumber)
+	enc.encode(s.SegmentSequenceNumber)
+	enc.encode(s.FirstLineNumberOfImageSegment)
+	enc.encode(s.Spare)
+
+	// Navigation correction information block
+	enc.encode(uint8(8))
+	enc.encode(uint16(correctionLength))
+	enc.encode(nc.CenterColumnOfRotation)
+	enc.encode(nc.CenterLineOfRotation)
+	enc.encode(nc.AmountOfRotationalCorrection)
+	enc.encode(uint16(len(nc.Corrections)))
+	enc.encode(nc.Corrections)
+	enc.encode(nc.Spare)
+
+	// Observation time information block
+	enc.encode(uint8(9))
+	enc.encode(uint16(observationLength))
+	enc.encode(uint16(len(ob.Observations)))
+	enc.encode(ob.Observations)
+	enc.encode(ob.Spare)
+
+	// Error information block
+	enc.encode(uint8(10))
+	enc.encode(uint32(errorLength))
+	enc.encode(uint16(len(ei.Errors)))
+	enc.encode(ei.Errors)
+	enc.encode(ei.Spare)
+
+	// Spare information block
+	enc.encode(uint8(11))
+	enc.encode(uint16(spareInformationLength))
+	enc.encode(f.SpareInfo.Spare)
+
+	// Image data
+	enc.encode(pixels)
+
+	return enc.err
+}
+
+// headerEncoder encodes fields, keeping the first error
+type headerEncoder struct {
+	w   io.Writer
+	o   binary.ByteOrder
+	err error
+}
+
+// encode writes a field, structs are written field by field without padding
+func (enc *headerEncoder) encode(v any) {
+	if enc.err != nil {
+		return
+	}
+	enc.err = binary.Write(enc.w, enc.o, v)
+}
@@ -0,0 +1,141 @@
+package himawari
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"io"
+	"slices"
+	"testing"
+)
+
+// testFile returns the header of a synthetic segment of a band with the calibration of the band type
+func testFile(o binary.ByteOrder, band uint16, columns, lines uint16, segment, total uint8) *HMFile {
+	f := NewSegment(int(band), int(columns), int(lines), int(segment), int(total), MJDTime(60248.56968491159))
+	f.BasicInfo.ByteOrder = o
+	f.BasicInfo.ObservationEndTime = 60248.57007103656
+	f.ProjectionInfo = projection()
+	f.CalibrationInfo = visibleFile().CalibrationInfo
+	if band >= 7 {
+		f.CalibrationInfo = infraredFile().CalibrationInfo
+	}
+	f.CalibrationInfo.BandNumber = band
+	f.InterCalibrationInfo = visibleFile().InterCalibrationInfo
+	return f
+}
+
+// testPixels returns n counts where every count is its index
+func testPixels(n int) []uint16 {
+	p := make([]uint16, n)
+	for i := range p {
+		p[i] = uint16(i % 60000)
+	}
+	return p
+}
+
+// encodeTest returns the encoded segment of f and its pixels
+func encodeTest(t testing.TB, f *HMFile, pixels []uint16) []byte {
+	buf := &bytes.Buffer{}
+	if err := Encode(buf, f, pixels); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testSegment returns an encoded segment as a section for Decode
+func testSegment(t testing.TB, f *HMFile, pixels []uint16) io.ReadCloser {
+	return io.NopCloser(bytes.NewReader(encodeTest(t, f, pixels)))
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		order binary.ByteOrder
+		band  uint16
+		// tables fills the correction, observation time and error tables
+		tables bool
+	}{
+		{name: "visible little endian", order: binary.LittleEndian, band: 2},
+		{name: "visible big endian", order: binary.BigEndian, band: 3, tables: true},
+		{name: "infrared little endian", order: binary.LittleEndian, band: 13, tables: true},
+		{name: "infrared big endian", order: binary.BigEndian, band: 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := testFile(tt.order, tt.band, 37, 11, 3, 10)
+			if tt.tables {
+				f.NavigationCorrectionInfo.CenterColumnOfRotation = 5500.5
+				f.NavigationCorrectionInfo.Corrections = []NavigationCorrection{
+					{LineNumberAfterRotation: 23, ShiftAmountForColumnCorrection: 0.25, ShiftAmountForLineCorrection: -0.5},
+					{LineNumberAfterRotation: 33, ShiftAmountForColumnCorrection: 0.5, ShiftAmountForLineCorrection: -1},
+				}
+				f.ObservationTimeInfo.Observations = []ObservationTime{
+					{LineNumber: 23, ObservationTime: 60248.5697},
+					{LineNumber: 33, ObservationTime: 60248.5698},
+				}
+				f.ErrorInfo.Errors = []ErrorInformation{{LineNumber: 25, NumberOfPixels: 4}}
+				f.InterCalibrationInfo.GSICSSlope = 1.01
+				f.InterCalibrationInfo.GSICSIntercept = -0.2
+				copy(f.InterCalibrationInfo.GSICSFileName[:], "gsics.nc")
+			}
+			pixels := testPixels(37 * 11)
+
+			h, err := DecodeFile(bytes.NewReader(encodeTest(t, f, pixels)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Block numbers, lengths and table sizes are computed by Encode
+			diff := cmp.Diff(f, h,
+				cmpopts.IgnoreFields(HMFile{}, "ImageData"),
+				cmpopts.IgnoreUnexported(HMFile{}),
+				cmp.FilterPath(func(p cmp.Path) bool {
+					field := p.Last().String()
+					return field == ".BlockNumber" || field == ".BlockLength" || field == ".TotalHeaderBlocks" ||
+						field == ".TotalHeaderLength" || field == ".TotalDataLength" || field == ".NumberOfBitsPerPixel" ||
+						field == ".NumberOfCorrectionInfo" || field == ".NumberOfObservationTimes" || field == ".NumberOfErrors"
+				}, cmp.Ignore()),
+				cmpopts.EquateEmpty(),
+			)
+			if diff != "" {
+				t.Errorf("decoded header differs: %s", diff)
+			}
+			if h.BasicInfo.TotalDataLength != uint32(2*len(pixels)) {
+				t.Errorf("expected %d bytes of data but got %d", 2*len(pixels), h.BasicInfo.TotalDataLength)
+			}
+
+			got := make([]uint16, len(pixels))
+			n, err := h.ReadLines(got)
+			if err != nil || n != 11 {
+				t.Fatalf("failed to read lines, read %d: %v", n, err)
+			}
+			if !slices.Equal(got, pixels) {
+				t.Errorf("decoded pixels differ")
+			}
+			if _, err = h.ReadPixel(); err != io.EOF {
+				t.Errorf("expected io.EOF after the last pixel but got %v", err)
+			}
+		})
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	f := testFile(binary.LittleEndian, 2, 4, 2, 1, 1)
+	if err := Encode(io.Discard, f, make([]uint16, 7)); err == nil {
+		t.Errorf("expected an error for a wrong amount of pixels")
+	}
+	// Only little and big endian can be flagged in the header
+	f.BasicInfo.ByteOrder = nativeOrder{}
+	if err := Encode(io.Discard, f, make([]uint16, 8)); err == nil {
+		t.Errorf("expected an error for an unsupported byte order")
+	}
+}
+
+// nativeOrder is a byte order that can't be encoded
+type nativeOrder struct {
+	binary.ByteOrder
+}
+
+func (nativeOrder) String() string {
+	return "native"
+}
@@ -0,0 +1,79 @@
+package himawari
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrTruncatedHeader is returned when a segment ends before its header does
+	ErrTruncatedHeader = errors.New("truncated HSD header")
+	// ErrInvalidByteOrder is returned when the byte order flag isn't little or big endian, usually not an HSD file
+	ErrInvalidByteOrder = errors.New("invalid HSD byte order")
+	// ErrNotSeekable is returned when seeking backwards in image data that isn't an io.Seeker, like bzip2 streams
+	ErrNotSeekable = errors.New("HSD image data can't seek backwards")
+	// ErrMissingSegment is returned when a segment of a band wasn't given
+	ErrMissingSegment = errors.New("missing HSD segment")
+	// ErrDuplicateSegment is returned when a segment of a band is given more than once
+	ErrDuplicateSegment = errors.New("duplicate HSD segment")
+)
+
+// BlockNumberError is returned when a header block isn't the one the spec defines at its position
+type BlockNumberError struct {
+	Expected uint8
+	Got      uint8
+}
+
+func (e *BlockNumberError) Error() string {
+	return fmt.Sprintf("expected HSD block %d but got block %d", e.Expected, e.Got)
+}
+
+// BlockLengthError is returned when the declared length of a header block doesn't match its spec length
+// Block 0 refers to the total header length
+type BlockLengthError struct {
+	Block    uint8
+	Expected uint32
+	Got      uint32
+}
+
+func (e *BlockLengthError) Error() string {
+	if e.Block == 0 {
+		return fmt.Sprintf("HSD header declares %d bytes but its blocks have %d bytes", e.Got, e.Expected)
+	}
+	return fmt.Sprintf("HSD block %d declares %d bytes but the spec defines %d bytes", e.Block, e.Got, e.Expected)
+}
+
+// VersionError is returned for file format versions the decoder doesn't support
+type VersionError struct {
+	Version string
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("unsupported HSD file format version %q", e.Version)
+}
+
+// MismatchError is returned when a segment doesn't belong to the same observation and band as the first segment
+type MismatchError struct {
+	Field    string
+	Expected string
+	Got      string
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("segment %s is %s but expected %s", e.Field, e.Got, e.Expected)
+}
+
+// SegmentError is returned when decoding one of the segments of a band fails
+type SegmentError struct {
+	// Segment sequence number, starting at 1
+	Segment int
+	Err     error
+}
+
+func (e *SegmentError) Error() string {
+	return fmt.Sprintf("segment %d: %s", e.Segment, e.Err)
+}
+
+func (e *SegmentError) Unwrap() error {
+	return e.Err
+}
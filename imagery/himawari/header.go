@@ -0,0 +1,93 @@
+package himawari
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// mjdEpoch is day 0 of the modified julian dates used by the HSD timestamps
+var mjdEpoch = time.Date(1858, 11, 17, 0, 0, 0, 0, time.UTC)
+
+// MJDTime returns the UTC time of a modified julian date, e.g. 60248.5 is 2023-10-31T12:00:00Z
+func MJDTime(mjd float64) time.Time {
+	days := math.Floor(mjd)
+	return mjdEpoch.AddDate(0, 0, int(days)).Add(time.Duration((mjd - days) * float64(24*time.Hour)))
+}
+
+// MJD returns the modified julian date of t, the inverse of MJDTime
+func MJD(t time.Time) float64 {
+	return float64(t.Sub(mjdEpoch)) / float64(24*time.Hour)
+}
+
+// SatelliteName returns the satellite that observed the segment, e.g. Himawari-9
+func (i BasicInformation) SatelliteName() string {
+	return trimNul(i.Satellite[:])
+}
+
+// ProcessingCenterName returns the center that processed the segment, e.g. MSC
+func (i BasicInformation) ProcessingCenterName() string {
+	return trimNul(i.ProcessingCenter[:])
+}
+
+// ObservationAreaName returns the observed area, e.g. FLDK for full disk
+func (i BasicInformation) ObservationAreaName() string {
+	return trimNul(i.ObservationArea[:])
+}
+
+// Version returns the file format version, e.g. 1.3
+func (i BasicInformation) Version() string {
+	return trimNul(i.FileFormatVersion[:])
+}
+
+// Name returns the file name of the segment, e.g. HS_H09_20231031_1340_B02_FLDK_R10_S0110.DAT
+func (i BasicInformation) Name() string {
+	return trimNul(i.FileName[:])
+}
+
+// StartTime returns when the observation of the segment started
+func (i BasicInformation) StartTime() time.Time {
+	return MJDTime(i.ObservationStartTime)
+}
+
+// EndTime returns when the observation of the segment ended
+func (i BasicInformation) EndTime() time.Time {
+	return MJDTime(i.ObservationEndTime)
+}
+
+// CreationTime returns when the segment file was created
+func (i BasicInformation) CreationTime() time.Time {
+	return MJDTime(i.FileCreationTime)
+}
+
+// Time returns when the line of the entry was observed
+func (o ObservationTime) Time() time.Time {
+	return MJDTime(o.ObservationTime)
+}
+
+// LineTime returns when a full disk line was observed, linearly interpolated between the observation time entries,
+// ok is false when the table is empty
+func (ob ObservationTimeInformationBlock) LineTime(line float64) (t time.Time, ok bool) {
+	observations := ob.Observations
+	if len(observations) == 0 {
+		return time.Time{}, false
+	}
+	// Index of the first observation after the line
+	i := sort.Search(len(observations), func(i int) bool {
+		return float64(observations[i].LineNumber) > line
+	})
+	if i == 0 {
+		return observations[0].Time(), true
+	}
+	if i == len(observations) {
+		return observations[len(observations)-1].Time(), true
+	}
+	prev, next := observations[i-1], observations[i]
+	f := (line - float64(prev.LineNumber)) / float64(next.LineNumber-prev.LineNumber)
+	return MJDTime(prev.ObservationTime + f*(next.ObservationTime-prev.ObservationTime)), true
+}
+
+// Name returns the file name of the GSICS correction, empty when there is no correction
+func (ci InterCalibrationInformationBlock) Name() string {
+	return trimNul(ci.GSICSFileName[:])
+}
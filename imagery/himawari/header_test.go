@@ -0,0 +1,87 @@
+package himawari
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMJDTime(t *testing.T) {
+	tests := []struct {
+		mjd  float64
+		want time.Time
+	}{
+		{mjd: 0, want: time.Date(1858, 11, 17, 0, 0, 0, 0, time.UTC)},
+		{mjd: 51544.5, want: time.Date(2000, 1, 1, 12, 0, 0, 0, time.UTC)},
+		// Observation start of the test segment, 13:40:20.776
+		{mjd: 60248.56968491159, want: time.Date(2023, 10, 31, 13, 40, 20, 776376000, time.UTC)},
+	}
+	for _, tt := range tests {
+		got := MJDTime(tt.mjd)
+		if d := got.Sub(tt.want); d < -time.Millisecond || d > time.Millisecond {
+			t.Errorf("expected %f to be %s but got %s", tt.mjd, tt.want, got)
+		}
+		if got := MJD(tt.want); math.Abs(got-tt.mjd) > 1e-8 {
+			t.Errorf("expected %s to be %f but got %f", tt.want, tt.mjd, got)
+		}
+	}
+}
+
+func TestBasicInformationAccessors(t *testing.T) {
+	i := BasicInformation{
+		Satellite:            [16]byte(c("Himawari-9")),
+		ProcessingCenter:     [16]byte(c("MSC")),
+		ObservationArea:      [4]byte(c("FLDK")),
+		FileFormatVersion:    [32]byte(c("1.3")),
+		FileName:             [128]byte(c("HS_H09_20231031_1340_B02_FLDK_R10_S0110.DAT")),
+		ObservationStartTime: 60248.5,
+		ObservationEndTime:   60248.75,
+		FileCreationTime:     60249,
+	}
+	strs := []struct{ got, want string }{
+		{i.SatelliteName(), "Himawari-9"},
+		{i.ProcessingCenterName(), "MSC"},
+		{i.ObservationAreaName(), "FLDK"},
+		{i.Version(), "1.3"},
+		{i.Name(), "HS_H09_20231031_1340_B02_FLDK_R10_S0110.DAT"},
+	}
+	for _, s := range strs {
+		if s.got != s.want {
+			t.Errorf("expected %q but got %q", s.want, s.got)
+		}
+	}
+	times := []struct{ got, want time.Time }{
+		{i.StartTime(), time.Date(2023, 10, 31, 12, 0, 0, 0, time.UTC)},
+		{i.EndTime(), time.Date(2023, 10, 31, 18, 0, 0, 0, time.UTC)},
+		{i.CreationTime(), time.Date(2023, 11, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range times {
+		if !tt.got.Equal(tt.want) {
+			t.Errorf("expected %s but got %s", tt.want, tt.got)
+		}
+	}
+}
+
+func TestLineTime(t *testing.T) {
+	ob := ObservationTimeInformationBlock{Observations: []ObservationTime{
+		{LineNumber: 1, ObservationTime: 60248.5},
+		{LineNumber: 101, ObservationTime: 60248.5 + 1.0/24},
+	}}
+	tests := []struct {
+		line float64
+		want time.Time
+	}{
+		{line: 0, want: time.Date(2023, 10, 31, 12, 0, 0, 0, time.UTC)},
+		{line: 51, want: time.Date(2023, 10, 31, 12, 30, 0, 0, time.UTC)},
+		{line: 500, want: time.Date(2023, 10, 31, 13, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, ok := ob.LineTime(tt.line)
+		if d := got.Sub(tt.want); !ok || d < -time.Millisecond || d > time.Millisecond {
+			t.Errorf("expected line %f at %s but got %s (%t)", tt.line, tt.want, got, ok)
+		}
+	}
+	if _, ok := (ObservationTimeInformationBlock{}).LineTime(1); ok {
+		t.Errorf("expected no time without observations")
+	}
+}
@@ -0,0 +1,154 @@
+package himawari
+
+import (
+	"math"
+	"sort"
+)
+
+// scaleUnit is the 2^-16 scale of CFAC and LFAC
+const scaleUnit = 1.0 / 65536
+
+// Column and line numbers follow the HSD spec, they are full disk numbers starting at 1, so a pixel at x (0 based) of a
+// full resolution full disk image is at column x+1 and a segment line y is at line FirstLineNumberOfImageSegment+y
+
+// FullDiskProjection returns the nominal projection of the full disk of a band, the projection every segment declares,
+// so positions can be found before any segment is downloaded
+func FullDiskProjection(band int) ProjectionInformationBlock {
+	// Column and line factors scale with the resolution
+	var factor uint32
+	switch Resolution(band) {
+	case "R05":
+		factor = 81865099
+	case "R10":
+		factor = 40932549
+	default:
+		factor = 20466275
+	}
+	offset := float32(DiskSize(band))/2 + 0.5
+	return ProjectionInformationBlock{
+		SubLon:                  140.7,
+		CFAC:                    factor,
+		LFAC:                    factor,
+		COFF:                    offset,
+		LOFF:                    offset,
+		DistanceFromEarthCenter: 42164,
+		EarthEquatorialRadius:   6378.137,
+		EarthPolarRadius:        6356.7523,
+		RatioDiff:               0.0066943844,
+		RatioPolar:              0.993305616,
+		RatioEquatorial:         1.006739501,
+		SDCoefficient:           1737122264,
+	}
+}
+
+// LatLon returns the geodetic latitude and longitude in degrees of a full disk column and line using the normalized
+// geostationary projection, ok is false if the position doesn't hit the Earth
+func (p ProjectionInformationBlock) LatLon(column, line float64) (lat, lon float64, ok bool) {
+	x := degToRad((column - float64(p.COFF)) / (scaleUnit * float64(p.CFAC)))
+	y := degToRad((line - float64(p.LOFF)) / (scaleUnit * float64(p.LFAC)))
+
+	cosX, sinX := math.Cos(x), math.Sin(x)
+	cosY, sinY := math.Cos(y), math.Sin(y)
+	a := cosY*cosY + p.RatioEquatorial*sinY*sinY
+	b := p.DistanceFromEarthCenter * cosX * cosY
+	sd := b*b - a*p.SDCoefficient
+	if sd < 0 {
+		return 0, 0, false
+	}
+	sn := (b - math.Sqrt(sd)) / a
+	s1 := p.DistanceFromEarthCenter - sn*cosX*cosY
+	s2 := sn * sinX * cosY
+	s3 := -sn * sinY
+	sxy := math.Hypot(s1, s2)
+
+	lon = radToDeg(math.Atan2(s2, s1)) + p.SubLon
+	lat = radToDeg(math.Atan(p.RatioEquatorial * s3 / sxy))
+	return lat, normalizeLon(lon), true
+}
+
+// ColumnLine returns the full disk column and line of a geodetic latitude and longitude in degrees, ok is false if the
+// position isn't visible from the satellite
+func (p ProjectionInformationBlock) ColumnLine(lat, lon float64) (column, line float64, ok bool) {
+	lon = degToRad(normalizeLon(lon - p.SubLon))
+	lat = degToRad(lat)
+
+	// Geocentric latitude and the Earth radius at it
+	phi := math.Atan(p.RatioPolar * math.Tan(lat))
+	cosPhi := math.Cos(phi)
+	re := p.EarthPolarRadius / math.Sqrt(1-p.RatioDiff*cosPhi*cosPhi)
+
+	r1 := p.DistanceFromEarthCenter - re*cosPhi*math.Cos(lon)
+	r2 := -re * cosPhi * math.Sin(lon)
+	r3 := re * math.Sin(phi)
+	// Behind the Earth limb
+	if r1*(r1-p.DistanceFromEarthCenter)+r2*r2+r3*r3 > 0 {
+		return 0, 0, false
+	}
+	rn := math.Sqrt(r1*r1 + r2*r2 + r3*r3)
+	x := radToDeg(math.Atan2(-r2, r1))
+	y := radToDeg(math.Asin(-r3 / rn))
+
+	column = float64(p.COFF) + x*scaleUnit*float64(p.CFAC)
+	line = float64(p.LOFF) + y*scaleUnit*float64(p.LFAC)
+	return column, line, true
+}
+
+// LatLon returns the latitude and longitude of a full disk column and line of the image, applying the navigation
+// correction shifts of the file, see ProjectionInformationBlock.LatLon
+func (f *HMFile) LatLon(column, line float64) (lat, lon float64, ok bool) {
+	dc, dl := f.NavigationCorrectionInfo.Shift(line)
+	return f.ProjectionInfo.LatLon(column+dc, line+dl)
+}
+
+// ColumnLine returns the full disk column and line in the image of a latitude and longitude, applying the navigation
+// correction shifts of the file, see ProjectionInformationBlock.ColumnLine
+func (f *HMFile) ColumnLine(lat, lon float64) (column, line float64, ok bool) {
+	column, line, ok = f.ProjectionInfo.ColumnLine(lat, lon)
+	if !ok {
+		return 0, 0, false
+	}
+	dc, dl := f.NavigationCorrectionInfo.Shift(line)
+	return column - dc, line - dl, true
+}
+
+// Shift returns the column and line shifts of a line, linearly interpolated between the correction table entries
+// Adding the shifts to an image position gives its corrected position on the projection
+func (nc NavigationCorrectionInformationBlock) Shift(line float64) (columnShift, lineShift float64) {
+	corrections := nc.Corrections
+	if len(corrections) == 0 {
+		return 0, 0
+	}
+	// Index of the first correction after the line
+	i := sort.Search(len(corrections), func(i int) bool {
+		return float64(corrections[i].LineNumberAfterRotation) > line
+	})
+	if i == 0 {
+		return float64(corrections[0].ShiftAmountForColumnCorrection), float64(corrections[0].ShiftAmountForLineCorrection)
+	}
+	if i == len(corrections) {
+		last := corrections[len(corrections)-1]
+		return float64(last.ShiftAmountForColumnCorrection), float64(last.ShiftAmountForLineCorrection)
+	}
+	prev, next := corrections[i-1], corrections[i]
+	t := (line - float64(prev.LineNumberAfterRotation)) / float64(next.LineNumberAfterRotation-prev.LineNumberAfterRotation)
+	columnShift = float64(prev.ShiftAmountForColumnCorrection) + t*float64(next.ShiftAmountForColumnCorrection-prev.ShiftAmountForColumnCorrection)
+	lineShift = float64(prev.ShiftAmountForLineCorrection) + t*float64(next.ShiftAmountForLineCorrection-prev.ShiftAmountForLineCorrection)
+	return columnShift, lineShift
+}
+
+func degToRad(d float64) float64 {
+	return d * math.Pi / 180
+}
+
+func radToDeg(r float64) float64 {
+	return r * 180 / math.Pi
+}
+
+// normalizeLon wraps a longitude into [-180, 180)
+func normalizeLon(lon float64) float64 {
+	lon = math.Mod(lon+180, 360)
+	if lon < 0 {
+		lon += 360
+	}
+	return lon - 180
+}
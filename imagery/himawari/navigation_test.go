@@ -0,0 +1,109 @@
+package himawari
+
+import (
+	"math"
+	"testing"
+)
+
+// projection returns the projection block of the 1km sample file
+func projection() ProjectionInformationBlock {
+	return ProjectionInformationBlock{
+		SubLon:                  140.7,
+		CFAC:                    40932549,
+		LFAC:                    40932549,
+		COFF:                    5500.5,
+		LOFF:                    5500.5,
+		DistanceFromEarthCenter: 42164,
+		EarthEquatorialRadius:   6378.137,
+		EarthPolarRadius:        6356.7523,
+		RatioDiff:               0.0066943844,
+		RatioPolar:              0.993305616,
+		RatioEquatorial:         1.006739501,
+		SDCoefficient:           1737122264,
+	}
+}
+
+func TestLatLonSubSatellitePoint(t *testing.T) {
+	lat, lon, ok := projection().LatLon(5500.5, 5500.5)
+	if !ok || math.Abs(lat) > 1e-9 || math.Abs(lon-140.7) > 1e-9 {
+		t.Errorf("expected the center to be at 0, 140.7 but got %f, %f (%t)", lat, lon, ok)
+	}
+	column, line, ok := projection().ColumnLine(0, 140.7)
+	if !ok || math.Abs(column-5500.5) > 1e-6 || math.Abs(line-5500.5) > 1e-6 {
+		t.Errorf("expected 0, 140.7 to be at the center but got %f, %f (%t)", column, line, ok)
+	}
+}
+
+func TestLatLonOrientation(t *testing.T) {
+	p := projection()
+	// Lines grow to the south and columns to the east
+	lat, lon, _ := p.LatLon(5500.5, 3000)
+	if lat <= 0 || math.Abs(lon-140.7) > 1e-9 {
+		t.Errorf("expected a northern latitude above the center but got %f, %f", lat, lon)
+	}
+	lat, lon, _ = p.LatLon(8000, 5500.5)
+	if lon <= 140.7 || math.Abs(lat) > 1e-9 {
+		t.Errorf("expected an eastern longitude right of the center but got %f, %f", lat, lon)
+	}
+	// Antimeridian is crossed on the east side of the disk
+	_, lon, _ = p.LatLon(10000, 5500.5)
+	if lon > -100 {
+		t.Errorf("expected longitude to be wrapped into the western hemisphere but got %f", lon)
+	}
+}
+
+func TestLatLonRoundTrip(t *testing.T) {
+	p := projection()
+	for _, pos := range [][2]float64{{-33.87, 151.21}, {35.68, 139.69}, {1.35, 103.82}, {-36.85, 174.76}, {21.3, -157.86}, {60, 140}} {
+		column, line, ok := p.ColumnLine(pos[0], pos[1])
+		if !ok {
+			t.Errorf("expected %v to be visible", pos)
+			continue
+		}
+		lat, lon, ok := p.LatLon(column, line)
+		if !ok || math.Abs(lat-pos[0]) > 1e-6 || math.Abs(lon-pos[1]) > 1e-6 {
+			t.Errorf("expected %v back from %f, %f but got %f, %f (%t)", pos, column, line, lat, lon, ok)
+		}
+	}
+}
+
+func TestLatLonOutsideEarth(t *testing.T) {
+	p := projection()
+	if _, _, ok := p.LatLon(1, 1); ok {
+		t.Errorf("expected the corner of the full disk to be in space")
+	}
+	// London is on the other side of the Earth
+	if _, _, ok := p.ColumnLine(51.5, -0.12); ok {
+		t.Errorf("expected London to not be visible")
+	}
+}
+
+func TestNavigationCorrection(t *testing.T) {
+	f := &HMFile{
+		ProjectionInfo: projection(),
+		NavigationCorrectionInfo: NavigationCorrectionInformationBlock{
+			Corrections: []NavigationCorrection{
+				{LineNumberAfterRotation: 5000, ShiftAmountForColumnCorrection: 1, ShiftAmountForLineCorrection: -2},
+				{LineNumberAfterRotation: 6001, ShiftAmountForColumnCorrection: 3, ShiftAmountForLineCorrection: 2},
+			},
+		},
+	}
+	dc, dl := f.NavigationCorrectionInfo.Shift(5500.5)
+	if dc != 2 || dl != 0 {
+		t.Errorf("expected interpolated shifts 2, 0 but got %f, %f", dc, dl)
+	}
+	dc, dl = f.NavigationCorrectionInfo.Shift(100)
+	if dc != 1 || dl != -2 {
+		t.Errorf("expected first shifts before the table but got %f, %f", dc, dl)
+	}
+
+	// The corrected center of the image is 2 columns right of the projection center
+	lat, lon, _ := f.LatLon(5500.5-2, 5500.5)
+	if math.Abs(lat) > 1e-9 || math.Abs(lon-140.7) > 1e-9 {
+		t.Errorf("expected shifted center to be at 0, 140.7 but got %f, %f", lat, lon)
+	}
+	column, line, _ := f.ColumnLine(0, 140.7)
+	if math.Abs(column-5498.5) > 1e-6 || math.Abs(line-5500.5) > 1e-6 {
+		t.Errorf("expected 0, 140.7 at the shifted center but got %f, %f", column, line)
+	}
+}
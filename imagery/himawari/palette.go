@@ -0,0 +1,169 @@
+package himawari
+
+import (
+	"image/color"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// ColorStop is the colour of a brightness temperature [K] in a palette
+type ColorStop struct {
+	Kelvin float64
+	Color  color.RGBA
+}
+
+// Palette maps brightness temperatures into colours, linearly interpolating between its stops sorted by Kelvin
+// Two stops at almost the same temperature make a hard step, temperatures outside the stops take the closest one
+type Palette struct {
+	Name  string
+	Stops []ColorStop
+}
+
+// grey returns an opaque grey
+func grey(v uint8) color.RGBA {
+	return color.RGBA{R: v, G: v, B: v, A: 255}
+}
+
+// celsius returns a temperature in Kelvin
+func celsius(c float64) float64 {
+	return c + 273.15
+}
+
+// step is how far apart the stops of a hard step are
+const step = 0.01
+
+var (
+	// PaletteGrayscaleInverted shows cold cloud tops white and warm surfaces black
+	PaletteGrayscaleInverted = Palette{
+		Name: "grayscale-inverted",
+		Stops: []ColorStop{
+			{Kelvin: nightColdest, Color: grey(255)},
+			{Kelvin: nightWarmest, Color: grey(0)},
+		},
+	}
+	// PaletteRainbow keeps warm temperatures grey and colours the cloud tops colder than -30°C, from blue to magenta
+	PaletteRainbow = Palette{
+		Name: "rainbow",
+		Stops: []ColorStop{
+			{Kelvin: celsius(-95), Color: grey(255)},
+			{Kelvin: celsius(-85), Color: color.RGBA{R: 255, B: 255, A: 255}},
+			{Kelvin: celsius(-75), Color: color.RGBA{R: 255, A: 255}},
+			{Kelvin: celsius(-65), Color: color.RGBA{R: 255, G: 255, A: 255}},
+			{Kelvin: celsius(-55), Color: color.RGBA{G: 255, A: 255}},
+			{Kelvin: celsius(-45), Color: color.RGBA{G: 255, B: 255, A: 255}},
+			{Kelvin: celsius(-30), Color: color.RGBA{B: 255, A: 255}},
+			{Kelvin: celsius(-30) + step, Color: grey(200)},
+			{Kelvin: celsius(40), Color: grey(0)},
+		},
+	}
+	// PaletteBD is the Dvorak BD enhancement curve used to estimate tropical cyclone intensity, warm surfaces are a
+	// grey ramp and cloud tops from 9°C are flat grey levels over the published whole degree intervals
+	PaletteBD = Palette{
+		Name: "bd",
+		Stops: []ColorStop{
+			// Cold dark grey colder than -85°C
+			{Kelvin: celsius(-110), Color: grey(80)},
+			{Kelvin: celsius(-85.5), Color: grey(80)},
+			// Cold medium grey from -81°C to -85°C
+			{Kelvin: celsius(-85.5) + step, Color: grey(140)},
+			{Kelvin: celsius(-80.5), Color: grey(140)},
+			// White from -76°C to -80°C
+			{Kelvin: celsius(-80.5) + step, Color: grey(255)},
+			{Kelvin: celsius(-75.5), Color: grey(255)},
+			// Black from -70°C to -75°C
+			{Kelvin: celsius(-75.5) + step, Color: grey(0)},
+			{Kelvin: celsius(-69.5), Color: grey(0)},
+			// Light grey from -64°C to -69°C
+			{Kelvin: celsius(-69.5) + step, Color: grey(190)},
+			{Kelvin: celsius(-63.5), Color: grey(190)},
+			// Medium grey from -54°C to -63°C
+			{Kelvin: celsius(-63.5) + step, Color: grey(110)},
+			{Kelvin: celsius(-53.5), Color: grey(110)},
+			// Dark grey from -42°C to -53°C
+			{Kelvin: celsius(-53.5) + step, Color: grey(60)},
+			{Kelvin: celsius(-41.5), Color: grey(60)},
+			// Off white from -31°C to -41°C
+			{Kelvin: celsius(-41.5) + step, Color: grey(220)},
+			{Kelvin: celsius(-30.5), Color: grey(220)},
+			// Warm medium grey from 9°C to -30°C
+			{Kelvin: celsius(-30.5) + step, Color: grey(120)},
+			{Kelvin: celsius(9.5), Color: grey(120)},
+			// Ramp into the warm surfaces
+			{Kelvin: celsius(40), Color: grey(0)},
+		},
+	}
+)
+
+// Palettes are the palettes selectable by name
+var Palettes = map[string]Palette{
+	PaletteGrayscaleInverted.Name: PaletteGrayscaleInverted,
+	PaletteRainbow.Name:           PaletteRainbow,
+	PaletteBD.Name:                PaletteBD,
+}
+
+// PaletteNames returns the names of the selectable palettes, sorted
+func PaletteNames() []string {
+	names := make([]string, 0, len(Palettes))
+	for name := range Palettes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Color returns the colour of a brightness temperature, black for NaN
+func (p Palette) Color(kelvin float64) color.RGBA {
+	stops := p.Stops
+	if math.IsNaN(kelvin) || len(stops) == 0 {
+		return color.RGBA{A: 255}
+	}
+	// Index of the first stop warmer than the temperature
+	i := sort.Search(len(stops), func(i int) bool {
+		return stops[i].Kelvin > kelvin
+	})
+	if i == 0 {
+		return stops[0].Color
+	}
+	if i == len(stops) {
+		return stops[len(stops)-1].Color
+	}
+	prev, next := stops[i-1], stops[i]
+	return blend(next.Color, prev.Color, (kelvin-prev.Kelvin)/(next.Kelvin-prev.Kelvin))
+}
+
+// blend returns a weighted by w plus b weighted by 1-w, opaque
+func blend(a, b color.RGBA, w float64) color.RGBA {
+	mix := func(a, b uint8) uint8 {
+		return uint8(math.Round(w*float64(a) + (1-w)*float64(b)))
+	}
+	return color.RGBA{R: mix(a.R, b.R), G: mix(a.G, b.G), B: mix(a.B, b.B), A: 255}
+}
+
+// countColors caches the colour of every count of the segments of a band, calibration may differ between segments
+type countColors struct {
+	palette Palette
+	tables  sync.Map
+}
+
+// at returns the colour of a count, infrared bands are coloured by brightness temperature and visible bands are grey
+func (c *countColors) at(h *HMFile, count uint16) color.RGBA {
+	if !h.IsInfrared() {
+		return greyPixel(h, count)
+	}
+	table, ok := c.tables.Load(h)
+	if !ok {
+		// Counts only use the valid bits, the error and outside the scan area counts are checked before
+		colors := make([]color.RGBA, 1<<h.CalibrationInfo.ValidNumberOfBitsPerPixel)
+		for i := range colors {
+			colors[i] = c.palette.Color(h.BrightnessTemperature(uint16(i)))
+		}
+		table, _ = c.tables.LoadOrStore(h, colors)
+	}
+	colors := table.([]color.RGBA)
+	if !h.ValidCount(count) || int(count) >= len(colors) {
+		return color.RGBA{A: 255}
+	}
+	return colors[count]
+}
@@ -0,0 +1,153 @@
+package himawari
+
+import (
+	"context"
+	"encoding/binary"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestPaletteStopsSorted(t *testing.T) {
+	for name, p := range Palettes {
+		if p.Name != name {
+			t.Errorf("palette %q is selected by %q", p.Name, name)
+		}
+		for i := 1; i < len(p.Stops); i++ {
+			if p.Stops[i].Kelvin <= p.Stops[i-1].Kelvin {
+				t.Errorf("palette %q stop %d at %fK isn't warmer than the previous one", name, i, p.Stops[i].Kelvin)
+			}
+		}
+	}
+}
+
+func TestPaletteColor(t *testing.T) {
+	p := Palette{Stops: []ColorStop{
+		{Kelvin: 200, Color: color.RGBA{R: 200, A: 255}},
+		{Kelvin: 250, Color: color.RGBA{R: 100, G: 50, A: 255}},
+		{Kelvin: 250 + step, Color: color.RGBA{B: 10, A: 255}},
+		{Kelvin: 300, Color: color.RGBA{B: 110, A: 255}},
+	}}
+	tests := []struct {
+		kelvin float64
+		want   color.RGBA
+	}{
+		{kelvin: 100, want: color.RGBA{R: 200, A: 255}},
+		{kelvin: 200, want: color.RGBA{R: 200, A: 255}},
+		{kelvin: 225, want: color.RGBA{R: 150, G: 25, A: 255}},
+		{kelvin: 250, want: color.RGBA{R: 100, G: 50, A: 255}},
+		{kelvin: 275 + step/2, want: color.RGBA{B: 60, A: 255}},
+		{kelvin: 300, want: color.RGBA{B: 110, A: 255}},
+		{kelvin: 400, want: color.RGBA{B: 110, A: 255}},
+		{kelvin: math.NaN(), want: color.RGBA{A: 255}},
+	}
+	for _, tt := range tests {
+		if got := p.Color(tt.kelvin); got != tt.want {
+			t.Errorf("expected %v at %fK but got %v", tt.want, tt.kelvin, got)
+		}
+	}
+}
+
+func TestPaletteGrayscaleInverted(t *testing.T) {
+	tests := []struct {
+		kelvin float64
+		want   uint8
+	}{
+		{kelvin: nightColdest - 10, want: 255},
+		{kelvin: nightColdest, want: 255},
+		{kelvin: (nightColdest + nightWarmest) / 2.0, want: 128},
+		{kelvin: nightWarmest, want: 0},
+		{kelvin: math.NaN(), want: 0},
+	}
+	for _, tt := range tests {
+		if got := PaletteGrayscaleInverted.Color(tt.kelvin); got != grey(tt.want) {
+			t.Errorf("expected grey %d for %f but got %v", tt.want, tt.kelvin, got)
+		}
+	}
+}
+
+func TestPaletteNames(t *testing.T) {
+	names := PaletteNames()
+	if len(names) != len(Palettes) {
+		t.Fatalf("expected %d names but got %v", len(Palettes), names)
+	}
+	for i := 1; i < len(names); i++ {
+		if names[i] <= names[i-1] {
+			t.Errorf("expected sorted names but got %v", names)
+		}
+	}
+}
+
+func TestDecodePalette(t *testing.T) {
+	// Segment 1 of a 20 pixels infrared disk split in 4, every count is its index
+	h := infraredFile()
+	tests := []struct {
+		name    string
+		palette *Palette
+		want    Palette
+	}{
+		{name: "default", want: PaletteGrayscaleInverted},
+		{name: "rainbow", palette: &PaletteRainbow, want: PaletteRainbow},
+		{name: "bd", palette: &PaletteBD, want: PaletteBD},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := Decode(context.Background(), testDisk(t, binary.LittleEndian, 13, 20, 4), Options{Palette: tt.palette})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, count := range []int{0, 7, 45, 99} {
+				want := tt.want.Color(h.BrightnessTemperature(uint16(count)))
+				if got := res.Image.RGBAAt(count%20, count/20); got != want {
+					t.Errorf("expected %v for count %d but got %v", want, count, got)
+				}
+			}
+		})
+	}
+
+	// Visible bands stay grey whatever the palette
+	res, err := Decode(context.Background(), testDisk(t, binary.LittleEndian, 3, 20, 4), Options{Palette: &PaletteRainbow})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.Image.RGBAAt(7, 0), greyPixel(res.Header, 7); got != want {
+		t.Errorf("expected visible count 7 to be %v but got %v", want, got)
+	}
+}
+
+func TestPaletteBDBands(t *testing.T) {
+	h := infraredFile()
+	// Count whose brightness temperature is the closest to a temperature, counts get colder as they grow
+	count := func(kelvin float64) uint16 {
+		best := uint16(0)
+		for c := uint16(1); c < 1<<h.CalibrationInfo.ValidNumberOfBitsPerPixel; c++ {
+			if math.Abs(h.BrightnessTemperature(c)-kelvin) < math.Abs(h.BrightnessTemperature(best)-kelvin) {
+				best = c
+			}
+		}
+		return best
+	}
+	tests := []struct {
+		name    string
+		celsius float64
+		want    uint8
+	}{
+		{name: "warm medium grey", celsius: -10.5, want: 120},
+		{name: "off white", celsius: -36, want: 220},
+		{name: "dark grey", celsius: -47.5, want: 60},
+		{name: "medium grey", celsius: -58.5, want: 110},
+		{name: "light grey", celsius: -66.5, want: 190},
+		{name: "black", celsius: -72.5, want: 0},
+		{name: "white", celsius: -78, want: 255},
+		{name: "cold medium grey", celsius: -83, want: 140},
+		{name: "cold dark grey", celsius: -90, want: 80},
+	}
+	colors := &countColors{palette: PaletteBD}
+	for _, tt := range tests {
+		c := count(celsius(tt.celsius))
+		if got := colors.at(h, c); got != grey(tt.want) {
+			t.Errorf("expected %s grey %d at %.1f°C (count %d, %.2fK) but got %v",
+				tt.name, tt.want, tt.celsius, c, h.BrightnessTemperature(c), got)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package himawari
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/sync/errgroup"
+	"image"
+	"io"
+	"math"
+)
+
+// SegmentOpener opens a full disk segment of a band, segment starts at 1
+type SegmentOpener func(ctx context.Context, segment int) (io.ReadCloser, error)
+
+// LatLonBox is a latitude and longitude bounding box in degrees, West > East crosses the antimeridian
+type LatLonBox struct {
+	South float64
+	West  float64
+	North float64
+	East  float64
+}
+
+// boxSamples is how many points are projected along each axis of a box to find the region covering it, the edges
+// of a box are curved on the disk so the corners alone aren't enough
+const boxSamples = 32
+
+// Region returns the smallest full disk region of band covering the visible part of the box, ok is false if none of
+// the box is visible from the satellite
+func (b LatLonBox) Region(band int) (image.Rectangle, bool) {
+	p := FullDiskProjection(band)
+	east := b.East
+	if east < b.West {
+		east += 360
+	}
+	minColumn, minLine := math.Inf(1), math.Inf(1)
+	maxColumn, maxLine := math.Inf(-1), math.Inf(-1)
+	for i := 0; i <= boxSamples; i++ {
+		lat := b.South + (b.North-b.South)*float64(i)/boxSamples
+		for j := 0; j <= boxSamples; j++ {
+			lon := b.West + (east-b.West)*float64(j)/boxSamples
+			column, line, ok := p.ColumnLine(lat, lon)
+			if !ok {
+				continue
+			}
+			minColumn, maxColumn = math.Min(minColumn, column), math.Max(maxColumn, column)
+			minLine, maxLine = math.Min(minLine, line), math.Max(maxLine, line)
+		}
+	}
+	if math.IsInf(minColumn, 1) {
+		return image.Rectangle{}, false
+	}
+	// Columns and lines start at 1 and are at the center of the pixels
+	r := image.Rect(
+		int(math.Floor(minColumn-0.5)), int(math.Floor(minLine-0.5)),
+		int(math.Ceil(maxColumn-0.5)), int(math.Ceil(maxLine-0.5)),
+	)
+	size := DiskSize(band)
+	r = r.Intersect(image.Rect(0, 0, size, size))
+	return r, !r.Empty()
+}
+
+// DecodeRegion decodes the region of the full disk of band, in full resolution pixels, into an image coloured like Decode
+// Only the segments intersecting the region are opened, they are decoded concurrently and closed when done
+// The image starts at 0,0 and is the region reduced by Downsample, areas of the box filter crossing a segment boundary
+// are only averaged with the lines of their segment
+func DecodeRegion(ctx context.Context, band int, open SegmentOpener, region image.Rectangle, opts Options) (*Result, error) {
+	size := DiskSize(band)
+	if !region.In(image.Rect(0, 0, size, size)) || region.Empty() {
+		return nil, fmt.Errorf("region %s isn't inside the %dx%d full disk", region, size, size)
+	}
+	downsample := max(opts.Downsample, 1)
+	width, height := region.Dx()/downsample, region.Dy()/downsample
+	if width == 0 || height == 0 {
+		return nil, fmt.Errorf("region %s is smaller than the downsample of %d", region, downsample)
+	}
+
+	// Nominal segments holding the first line of every output line
+	linesPerSegment := size / FullDiskSegments
+	first := region.Min.Y/linesPerSegment + 1
+	last := (region.Min.Y+(height-1)*downsample)/linesPerSegment + 1
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	colors := &countColors{palette: opts.palette()}
+	headers := make([]*HMFile, last-first+1)
+	group, ctx := errgroup.WithContext(ctx)
+	for segment := first; segment <= last; segment++ {
+		segment := segment
+		group.Go(func() error {
+			rc, err := open(ctx, segment)
+			if err != nil {
+				return &SegmentError{Segment: segment, Err: err}
+			}
+			defer rc.Close()
+			h, err := DecodeFile(rc)
+			if err != nil {
+				return &SegmentError{Segment: segment, Err: err}
+			}
+			if err = checkRegionSegment(h, band, segment); err != nil {
+				return &SegmentError{Segment: segment, Err: err}
+			}
+			headers[segment-first] = h
+			err = decodeRegionSection(ctx, h, region, downsample, opts.Filter, width, height, func(h *HMFile, x, y int, count uint16) {
+				img.SetRGBA(x, y, colors.at(h, count))
+			})
+			if err != nil {
+				return &SegmentError{Segment: segment, Err: err}
+			}
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+
+	return &Result{Image: img, Header: headers[0]}, nil
+}
+
+// checkRegionSegment returns a *MismatchError when h isn't the segment of band that was opened
+func checkRegionSegment(h *HMFile, band, segment int) error {
+	if int(h.CalibrationInfo.BandNumber) != band {
+		return &MismatchError{Field: "band", Expected: fmt.Sprint(band), Got: fmt.Sprint(h.CalibrationInfo.BandNumber)}
+	}
+	if int(h.SegmentInfo.SegmentSequenceNumber) != segment {
+		return &MismatchError{Field: "segment", Expected: fmt.Sprint(segment), Got: fmt.Sprint(h.SegmentInfo.SegmentSequenceNumber)}
+	}
+	return nil
+}
+
+// decodeRegionSection decodes the output lines of region whose first line is in the segment h
+func decodeRegionSection(ctx context.Context, h *HMFile, region image.Rectangle, downsample int, filter Filter, width, height int, set pixelFunc) error {
+	columns, lines := int(h.DataInfo.NumberOfColumns), int(h.DataInfo.NumberOfLines)
+	if region.Max.X > columns {
+		return fmt.Errorf("region %s is wider than the %d columns of the segment", region, columns)
+	}
+	firstLine := int(h.SegmentInfo.FirstLineNumberOfImageSegment) - 1
+	areaLines := 1
+	if filter == FilterBox {
+		areaLines = downsample
+	}
+
+	// First output line starting at or after the first line of the segment
+	startY := 0
+	if firstLine > region.Min.Y {
+		startY = (firstLine - region.Min.Y + downsample - 1) / downsample
+	}
+	for y := startY; y < height; y++ {
+		line := region.Min.Y + y*downsample - firstLine
+		if line >= lines {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n := min(areaLines, lines-line)
+		counts, err := h.ReadRegion(region.Min.X, line, width*downsample, n)
+		if err != nil {
+			return fmt.Errorf("failed to read line %d: %w", line, err)
+		}
+		for x := 0; x < width; x++ {
+			if filter == FilterBox {
+				set(h, x, y, areaCount(h, counts[x*downsample:], width*downsample, downsample, n))
+			} else {
+				set(h, x, y, counts[x*downsample])
+			}
+		}
+	}
+	return nil
+}
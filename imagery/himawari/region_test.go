@@ -0,0 +1,198 @@
+package himawari
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestDecodeRegionSection(t *testing.T) {
+	// Second segment of a 12 lines disk, decoding the region 2,4-10,12 at half the resolution into 4x4 pixels
+	region := image.Rect(2, 4, 10, 12)
+	tests := []struct {
+		name   string
+		filter Filter
+		want   func(line, column int) uint16
+	}{
+		{name: "skip", filter: FilterSkip, want: func(line, column int) uint16 { return uint16(line*100 + column) }},
+		// Averages of line*100+column over 2x2 areas, rounded up
+		{name: "box", filter: FilterBox, want: func(line, column int) uint16 { return uint16(line*100 + column + 51) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := memorySegment(12, 6, gridCounts(12, 6))
+			h.SegmentInfo.SegmentTotalNumber = 2
+			h.SegmentInfo.SegmentSequenceNumber = 2
+			h.SegmentInfo.FirstLineNumberOfImageSegment = 7
+			got := map[image.Point]uint16{}
+			err := decodeRegionSection(context.Background(), h, region, 2, tt.filter, 4, 4, func(_ *HMFile, x, y int, count uint16) {
+				got[image.Pt(x, y)] = count
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Line 4 of the disk belongs to the first segment, lines 6, 8 and 10 are lines 0, 2 and 4 of the segment
+			if len(got) != 12 {
+				t.Errorf("expected 12 pixels but got %d", len(got))
+			}
+			for y := 1; y < 4; y++ {
+				for x := 0; x < 4; x++ {
+					want := tt.want(2*(y-1), 2+2*x)
+					if got[image.Pt(x, y)] != want {
+						t.Errorf("expected %d at %d,%d but got %d", want, x, y, got[image.Pt(x, y)])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeRegionOutsideDisk(t *testing.T) {
+	opened := false
+	open := func(ctx context.Context, segment int) (io.ReadCloser, error) {
+		opened = true
+		return nil, errors.New("unexpected open")
+	}
+	for _, region := range []image.Rectangle{image.Rect(-1, 0, 10, 10), image.Rect(5000, 5000, 5600, 5100), {}} {
+		if _, err := DecodeRegion(context.Background(), 7, open, region, Options{}); err == nil {
+			t.Errorf("expected an error for region %s", region)
+		}
+	}
+	if opened {
+		t.Errorf("expected no segment to be opened")
+	}
+}
+
+func TestLatLonBoxRegion(t *testing.T) {
+	// Box around the sub satellite point, which is at the center of the disk
+	r, ok := LatLonBox{South: -1, West: 139.7, North: 1, East: 141.7}.Region(2)
+	if !ok || !image.Pt(5500, 5500).In(r) || r.Dx() > 250 || r.Dy() > 250 {
+		t.Errorf("expected a small region around the center but got %s (%t)", r, ok)
+	}
+	// Northern hemisphere is at the top of the disk
+	r, ok = LatLonBox{South: 30, West: 130, North: 45, East: 150}.Region(2)
+	if !ok || r.Max.Y > 5500 {
+		t.Errorf("expected a region above the center but got %s (%t)", r, ok)
+	}
+	// Crossing the antimeridian east of the satellite
+	r, ok = LatLonBox{South: -10, West: 170, North: 10, East: -170}.Region(2)
+	if !ok || r.Min.X < 5500 {
+		t.Errorf("expected a region right of the center but got %s (%t)", r, ok)
+	}
+	// Other side of the Earth
+	if r, ok = (LatLonBox{South: -10, West: -50, North: 10, East: -30}).Region(2); ok {
+		t.Errorf("expected the box not to be visible but got %s", r)
+	}
+}
+
+// regionDisk returns the encoded segments of a 60 columns wide band 13 full disk, every count is 5 times its line in
+// the segment plus its column so the lines of neighbouring segments are far apart
+func regionDisk(t *testing.T) [][]byte {
+	const columns = 60
+	lines := DiskSize(13) / FullDiskSegments
+	segments := make([][]byte, FullDiskSegments)
+	for s := range segments {
+		counts := make([]uint16, columns*lines)
+		for i := range counts {
+			counts[i] = uint16(i/columns*5 + i%columns)
+		}
+		segments[s] = encodeTest(t, testFile(binary.LittleEndian, 13, columns, uint16(lines), uint8(s+1), FullDiskSegments), counts)
+	}
+	return segments
+}
+
+// recordingOpener opens segments from memory, recording the ones opened and closed
+type recordingOpener struct {
+	segments [][]byte
+	mu       sync.Mutex
+	opened   []int
+	closed   []int
+}
+
+func (o *recordingOpener) open(_ context.Context, segment int) (io.ReadCloser, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.opened = append(o.opened, segment)
+	return &recordingSegment{Reader: bytes.NewReader(o.segments[segment-1]), opener: o, segment: segment}, nil
+}
+
+type recordingSegment struct {
+	io.Reader
+	opener  *recordingOpener
+	segment int
+}
+
+func (s *recordingSegment) Close() error {
+	s.opener.mu.Lock()
+	defer s.opener.mu.Unlock()
+	s.opener.closed = append(s.opener.closed, s.segment)
+	return nil
+}
+
+func TestDecodeRegion(t *testing.T) {
+	segments := regionDisk(t)
+	sections := make([]io.ReadCloser, len(segments))
+	for i, s := range segments {
+		sections[i] = io.NopCloser(bytes.NewReader(s))
+	}
+	full, err := Decode(context.Background(), sections, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Segments have 550 lines, the region crosses from segment 2 into segment 3
+	region := image.Rect(10, 1000, 50, 1200)
+	for _, downsample := range []int{1, 2} {
+		o := &recordingOpener{segments: segments}
+		res, err := DecodeRegion(context.Background(), 13, o.open, region, Options{Downsample: downsample})
+		if err != nil {
+			t.Fatal(err)
+		}
+		slices.Sort(o.opened)
+		slices.Sort(o.closed)
+		if want := []int{2, 3}; !slices.Equal(o.opened, want) || !slices.Equal(o.closed, want) {
+			t.Errorf("expected segments %v to be opened and closed but got %v and %v", want, o.opened, o.closed)
+		}
+		if b := res.Image.Bounds(); b != image.Rect(0, 0, 40/downsample, 200/downsample) {
+			t.Fatalf("expected a %dx%d image but got %s", 40/downsample, 200/downsample, b)
+		}
+		for y := 0; y < 200/downsample; y++ {
+			for x := 0; x < 40/downsample; x++ {
+				want := full.Image.RGBAAt(region.Min.X+x*downsample, region.Min.Y+y*downsample)
+				if got := res.Image.RGBAAt(x, y); got != want {
+					t.Fatalf("expected %v at %d,%d with a downsample of %d but got %v", want, x, y, downsample, got)
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeRegionBoxSegmentBoundary(t *testing.T) {
+	o := &recordingOpener{segments: regionDisk(t)}
+	// The first output line averages lines 1098 to 1101, the last two lines of segment 2 and the first two of segment 3
+	region := image.Rect(8, 1098, 24, 1138)
+	res, err := DecodeRegion(context.Background(), 13, o.open, region, Options{Downsample: 4, Filter: FilterBox})
+	if err != nil {
+		t.Fatal(err)
+	}
+	colors := &countColors{palette: PaletteGrayscaleInverted}
+	for x := 0; x < 4; x++ {
+		// Average of lines 548 and 549 of segment 2, 5*548.5 plus the columns 8+4x to 11+4x, rounded
+		column := region.Min.X + 4*x
+		want := colors.at(res.Header, uint16(2744+column))
+		if got := res.Image.RGBAAt(x, 0); got != want {
+			t.Errorf("expected %v at %d,0 but got %v", want, x, got)
+		}
+		// Lines 2 to 5 of segment 3, 5*3.5 plus the columns, rounded
+		want = colors.at(res.Header, uint16(19+column))
+		if got := res.Image.RGBAAt(x, 1); got != want {
+			t.Errorf("expected %v at %d,1 but got %v", want, x, got)
+		}
+	}
+}
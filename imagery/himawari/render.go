@@ -0,0 +1,429 @@
+package himawari
+
+import (
+	"compress/bzip2"
+	"context"
+	"fmt"
+	"golang.org/x/sync/errgroup"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Filter is how pixels are combined when downsampling
+type Filter int
+
+const (
+	// FilterSkip keeps one of every Downsample pixels in both axis, the fastest but it aliases on cloud edges
+	FilterSkip Filter = iota
+	// FilterBox averages every Downsample x Downsample area, ignoring error and outside the scan area pixels
+	FilterBox
+)
+
+// Options controls how segments are decoded into an image
+type Options struct {
+	// Downsample reduces both axis by Downsample, 1 decodes the full resolution
+	Downsample int
+	// Filter used when downsampling
+	Filter Filter
+	// AllowMissing decodes the segments that are available instead of failing, nil and undecodable segments are
+	// reported in Result.Missing and filled with NoData
+	AllowMissing bool
+	// NoData is the colour of missing segments
+	NoData color.RGBA
+	// Palette colours infrared bands by brightness temperature, nil is PaletteGrayscaleInverted
+	// Visible bands are always grey
+	Palette *Palette
+}
+
+// palette returns the palette of infrared bands
+func (o Options) palette() Palette {
+	if o.Palette == nil {
+		return PaletteGrayscaleInverted
+	}
+	return *o.Palette
+}
+
+// Result is a decoded full disk image alongside the metadata of its first segment
+type Result struct {
+	Image  *image.RGBA
+	Header *HMFile
+	// Missing holds the sequence numbers of the segments that couldn't be decoded, sorted, only with AllowMissing
+	Missing []int
+}
+
+// OpenFiles Returns a list of file sections sorted asc, .bz2 sections are decompressed while read
+func OpenFiles(dir string, pattern string) ([]io.ReadCloser, error) {
+	var filesWithPattern []string
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %q directory: %s", dir, err)
+	}
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), pattern) {
+			filesWithPattern = append(filesWithPattern, dir+"/"+file.Name())
+		}
+	}
+	slices.Sort(filesWithPattern)
+	var oFiles []io.ReadCloser
+	for _, f := range filesWithPattern {
+		ff, err := os.Open(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %q file: %s", f, err)
+		}
+		if strings.HasSuffix(f, ".bz2") {
+			oFiles = append(oFiles, NewBzip2Reader(ff))
+		} else {
+			oFiles = append(oFiles, ff)
+		}
+	}
+
+	return oFiles, nil
+}
+
+// bzip2Reader decompresses a bzip2 stream while closing the underlying one
+type bzip2Reader struct {
+	io.Reader
+	io.Closer
+}
+
+// NewBzip2Reader wraps a compressed segment, such as the .DAT.bz2 files, so it can be passed to Decode
+func NewBzip2Reader(rc io.ReadCloser) io.ReadCloser {
+	return bzip2Reader{Reader: bzip2.NewReader(rc), Closer: rc}
+}
+
+// Aux struct to store decode metadata
+type sectionDecode struct {
+	width        int
+	height       int
+	downsample   int
+	filter       Filter
+	scale        float64
+	scaledWidth  int
+	scaledHeight int
+}
+
+// pixelFunc receives the count of a decoded pixel and its position in the full disk image
+type pixelFunc func(h *HMFile, x, y int, count uint16)
+
+func decodeSection(ctx context.Context, h *HMFile, d sectionDecode, set pixelFunc) error {
+	// Start and End Y are the relative positions for the final image based in a section
+	startY := d.scaledHeight * int(h.SegmentInfo.SegmentSequenceNumber-1)
+	endY := startY + d.scaledHeight
+	if d.filter == FilterBox && d.downsample > 1 {
+		return decodeSectionBox(ctx, h, d, startY, endY, set)
+	}
+	// Amount of lines for down sample skip
+	skipPx := d.downsample - 1
+	row := make([]uint16, d.width)
+	for y := startY; y < endY; y++ {
+		// Stop early when a sibling segment failed or the caller gave up
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := h.ReadRow(row)
+		if err != nil {
+			return fmt.Errorf("failed to read line at y %d: %w", y, err)
+		}
+		for x := 0; x < d.scaledWidth; x++ {
+			set(h, x, y, row[x*d.downsample])
+		}
+		err = h.Skip(d.width * skipPx)
+		if err != nil {
+			return fmt.Errorf("failed to skip %d lines at y %d: %w", skipPx, y, err)
+		}
+	}
+	return nil
+}
+
+// decodeSectionBox decodes a section averaging the valid counts of every downsample x downsample area
+func decodeSectionBox(ctx context.Context, h *HMFile, d sectionDecode, startY, endY int, set pixelFunc) error {
+	lines := make([]uint16, d.width*d.downsample)
+	for y := startY; y < endY; y++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := h.ReadLines(lines)
+		if err != nil {
+			return fmt.Errorf("failed to read %d lines at y %d: %w", d.downsample, y, err)
+		}
+		for x := 0; x < d.scaledWidth; x++ {
+			set(h, x, y, areaCount(h, lines[x*d.downsample:], d.width, d.downsample, n))
+		}
+	}
+	return nil
+}
+
+// areaCount returns the rounded average of the valid counts of a width x height area of counts with a stride, the
+// outside the scan area count if none is valid
+func areaCount(h *HMFile, counts []uint16, stride, width, height int) uint16 {
+	var sum, n uint32
+	for y := 0; y < height; y++ {
+		for _, count := range counts[y*stride : y*stride+width] {
+			if h.ValidCount(count) {
+				sum += uint32(count)
+				n++
+			}
+		}
+	}
+	if n == 0 {
+		// Nothing observed in the area, it is usually space
+		return h.CalibrationInfo.CountValueOfPixelsOutsideScanArea
+	}
+	return uint16((sum + n/2) / n)
+}
+
+// Decode decodes every segment of a band into a single full disk image, closing the segments when done
+// Visible bands are grey and infrared bands are coloured by Options.Palette
+// Segments are decoded concurrently, the first failing segment cancels the others and is returned as a *SegmentError
+// Segments are placed by their sequence number and must belong to the same observation, see Options.AllowMissing
+func Decode(ctx context.Context, sections []io.ReadCloser, opts Options) (*Result, error) {
+	defer closeSections(sections)
+	return decode(ctx, sectionSegments(sections), opts)
+}
+
+// DecodeFullDisk is Decode opening the FullDiskSegments segments of a band with open, every segment is opened by the
+// goroutine decoding it with a context canceled when another segment fails, and closed when done
+// Segments that fail to open are missing segments, see Options.AllowMissing
+func DecodeFullDisk(ctx context.Context, open SegmentOpener, opts Options) (*Result, error) {
+	return decode(ctx, fullDiskSegments(open), opts)
+}
+
+func decode(ctx context.Context, s segments, opts Options) (*Result, error) {
+	var img *image.RGBA
+	colors := &countColors{palette: opts.palette()}
+	header, missing, err := decodeSegments(ctx, s, opts, func(_ *HMFile, width, height int) error {
+		img = image.NewRGBA(image.Rect(0, 0, width, height))
+		return nil
+	}, func(h *HMFile, x, y int, count uint16) {
+		img.SetRGBA(x, y, colors.at(h, count))
+	})
+	if err != nil {
+		return nil, err
+	}
+	fillMissing(img, int(header.SegmentInfo.SegmentTotalNumber), missing, opts.NoData)
+
+	return &Result{Image: img, Header: header, Missing: missing}, nil
+}
+
+// segments are the segments of a band, opened by their position from 1 to count
+type segments struct {
+	count int
+	open  SegmentOpener
+}
+
+// fullDiskSegments returns the FullDiskSegments segments of a band opened by open
+func fullDiskSegments(open SegmentOpener) segments {
+	return segments{count: FullDiskSegments, open: open}
+}
+
+// sectionSegments returns already opened segments, nil ones are missing, they are closed by the caller
+func sectionSegments(sections []io.ReadCloser) segments {
+	return segments{count: len(sections), open: func(_ context.Context, segment int) (io.ReadCloser, error) {
+		if sections[segment-1] == nil {
+			return nil, ErrMissingSegment
+		}
+		return io.NopCloser(sections[segment-1]), nil
+	}}
+}
+
+// closeSections closes the sections that aren't nil
+func closeSections(sections []io.ReadCloser) {
+	for _, s := range sections {
+		if s != nil {
+			_ = s.Close()
+		}
+	}
+}
+
+// fillMissing paints the lines of the missing segments of a full disk image with c
+func fillMissing(img *image.RGBA, total int, missing []int, c color.RGBA) {
+	b := img.Bounds()
+	for _, segment := range missing {
+		y0, y1 := segmentLines(b.Dy(), total, segment)
+		draw.Draw(img, image.Rect(b.Min.X, b.Min.Y+y0, b.Max.X, b.Min.Y+y1), image.NewUniform(c), image.Point{}, draw.Src)
+	}
+}
+
+// segmentLines returns the lines [start, end) of a segment in a full disk of height lines split into total segments
+func segmentLines(height, total, segment int) (int, int) {
+	lines := height / total
+	return lines * (segment - 1), lines * segment
+}
+
+// decodeSegments decodes every segment of a band, each one is opened by the goroutine decoding it and closed when done
+// The segments are opened in order until one has a header, init is called with it and the size of the full disk
+// before any other segment is opened or any pixel decoded, an error rejects the band
+// Segments that couldn't be opened or decoded are returned when opts.AllowMissing is set, their pixels may be
+// partially set
+func decodeSegments(ctx context.Context, s segments, opts Options, init func(h *HMFile, width, height int) error, set pixelFunc) (*HMFile, []int, error) {
+	var first *HMFile
+	var d sectionDecode
+	var mu sync.Mutex
+	var decoded []bool
+	group, ctx := errgroup.WithContext(ctx)
+
+	// decodeSegment decodes the pixels of a segment
+	decodeSegment := func(h *HMFile) error {
+		segment := int(h.SegmentInfo.SegmentSequenceNumber)
+		// Mismatched segments are never ignored, they would be drawn over the right ones
+		if err := checkSegment(first, h); err != nil {
+			return &SegmentError{Segment: segment, Err: err}
+		}
+		mu.Lock()
+		duplicate := decoded[segment-1]
+		decoded[segment-1] = true
+		mu.Unlock()
+		if duplicate {
+			return &SegmentError{Segment: segment, Err: ErrDuplicateSegment}
+		}
+		if err := decodeSection(ctx, h, d, set); err != nil {
+			mu.Lock()
+			decoded[segment-1] = false
+			mu.Unlock()
+			return ignoreMissing(ctx, opts, &SegmentError{Segment: segment, Err: err})
+		}
+		return nil
+	}
+	// openSegment opens the segment at position i and decodes its header, the sequence number is unknown without the
+	// header so errors use the position
+	openSegment := func(i int) (io.ReadCloser, *HMFile, error) {
+		rc, err := s.open(ctx, i)
+		if err != nil {
+			return nil, nil, &SegmentError{Segment: i, Err: err}
+		}
+		h, err := DecodeFile(rc)
+		if err != nil {
+			_ = rc.Close()
+			return nil, nil, &SegmentError{Segment: i, Err: err}
+		}
+		return rc, h, nil
+	}
+
+	group.Go(func() error {
+		// With AllowMissing any decodable segment gives the file info, the ones before it are reported as missing
+		var missing error
+		for i := 1; i <= s.count; i++ {
+			rc, h, err := openSegment(i)
+			if err != nil {
+				if missing, err = err, ignoreMissing(ctx, opts, err); err != nil {
+					return err
+				}
+				continue
+			}
+			defer rc.Close()
+			total := int(h.SegmentInfo.SegmentTotalNumber)
+			if total == 0 {
+				return &SegmentError{Segment: i, Err: fmt.Errorf("segment declares 0 segments")}
+			}
+			first, d, decoded = h, calculateScaling(h, opts), make([]bool, total)
+			if err = init(h, d.scaledWidth, d.scaledHeight*total); err != nil {
+				return err
+			}
+
+			// Decode the other segments, the group context is cancelled on the first error
+			for j := i + 1; j <= s.count; j++ {
+				j := j
+				group.Go(func() error {
+					rc, h, err := openSegment(j)
+					if err != nil {
+						return ignoreMissing(ctx, opts, err)
+					}
+					defer rc.Close()
+					return decodeSegment(h)
+				})
+			}
+			return decodeSegment(h)
+		}
+		if missing != nil {
+			return fmt.Errorf("no segments to decode: %w", missing)
+		}
+		return fmt.Errorf("no segments to decode")
+	})
+	if err := group.Wait(); err != nil {
+		return nil, nil, err
+	}
+
+	var missing []int
+	for i, ok := range decoded {
+		if !ok {
+			missing = append(missing, i+1)
+		}
+	}
+	if len(missing) > 0 && !opts.AllowMissing {
+		return nil, nil, &SegmentError{Segment: missing[0], Err: ErrMissingSegment}
+	}
+
+	return first, missing, nil
+}
+
+// ignoreMissing returns nil for errors of segments that are reported as missing instead, when allowed
+func ignoreMissing(ctx context.Context, opts Options, err error) error {
+	// Cancellations aren't a problem of the segment
+	if !opts.AllowMissing || ctx.Err() != nil {
+		return err
+	}
+	return nil
+}
+
+// checkSegment returns a *MismatchError when h isn't a segment of the same observation and band as first
+func checkSegment(first, h *HMFile) error {
+	checks := []struct {
+		field    string
+		expected any
+		got      any
+	}{
+		{"satellite", first.BasicInfo.SatelliteName(), h.BasicInfo.SatelliteName()},
+		{"observation area", first.BasicInfo.ObservationAreaName(), h.BasicInfo.ObservationAreaName()},
+		{"timeline", first.BasicInfo.ObservationTimeline, h.BasicInfo.ObservationTimeline},
+		{"band", first.CalibrationInfo.BandNumber, h.CalibrationInfo.BandNumber},
+		{"columns", first.DataInfo.NumberOfColumns, h.DataInfo.NumberOfColumns},
+		{"lines", first.DataInfo.NumberOfLines, h.DataInfo.NumberOfLines},
+		{"total segments", first.SegmentInfo.SegmentTotalNumber, h.SegmentInfo.SegmentTotalNumber},
+	}
+	for _, c := range checks {
+		if c.expected != c.got {
+			return &MismatchError{Field: c.field, Expected: fmt.Sprint(c.expected), Got: fmt.Sprint(c.got)}
+		}
+	}
+	if h.SegmentInfo.SegmentSequenceNumber < 1 || h.SegmentInfo.SegmentSequenceNumber > h.SegmentInfo.SegmentTotalNumber {
+		return &MismatchError{
+			Field:    "segment",
+			Expected: fmt.Sprintf("1-%d", h.SegmentInfo.SegmentTotalNumber),
+			Got:      fmt.Sprint(h.SegmentInfo.SegmentSequenceNumber),
+		}
+	}
+	return nil
+}
+
+func calculateScaling(h *HMFile, opts Options) sectionDecode {
+	downsample := max(opts.Downsample, 1)
+	d := sectionDecode{
+		width:      int(h.DataInfo.NumberOfColumns),
+		height:     int(h.DataInfo.NumberOfLines),
+		downsample: downsample,
+		filter:     opts.Filter,
+		scale:      1.0 / float64(downsample),
+	}
+	d.scaledWidth = int(d.scale * float64(d.width))
+	d.scaledHeight = int(d.scale * float64(d.height))
+
+	return d
+}
+
+// greyPixel maps a count into grey, black for error and outside the scan area pixels
+func greyPixel(h *HMFile, data uint16) color.RGBA {
+	if !h.ValidCount(data) {
+		return color.RGBA{A: 255}
+	}
+	// Get a number between 0 and 1 from max number of pixels
+	// different bands has different number of pixels bits, e.g., band 03 has 11
+	coef := float64(data) / (math.Pow(2., float64(h.CalibrationInfo.ValidNumberOfBitsPerPixel)) - 2.)
+	return grey(uint8(math.Min(coef*255, 255)))
+}
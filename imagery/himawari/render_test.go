@@ -0,0 +1,445 @@
+package himawari
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// closeRecorder records if a segment was closed
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestDecodeSegmentError(t *testing.T) {
+	segments := []*closeRecorder{
+		{Reader: bytes.NewReader([]byte{1, 26, 1, 11, 0})},
+		{Reader: bytes.NewReader(nil)},
+	}
+	_, err := Decode(context.Background(), []io.ReadCloser{segments[0], segments[1]}, Options{})
+
+	var segErr *SegmentError
+	if !errors.As(err, &segErr) || segErr.Segment != 1 {
+		t.Errorf("expected a segment 1 error but got %v", err)
+	}
+	if !errors.Is(err, ErrTruncatedHeader) {
+		t.Errorf("expected the segment error to wrap the decode error but got %v", err)
+	}
+	for i, s := range segments {
+		if !s.closed {
+			t.Errorf("expected segment %d to be closed", i+1)
+		}
+	}
+}
+
+// slowSegment is a segment whose pixels are read a few bytes at a time, slow enough for a full decode to take seconds
+type slowSegment struct {
+	io.Reader
+	// header is how many bytes are left to read before the pixels
+	header int
+	mu     sync.Mutex
+	closed bool
+}
+
+func (s *slowSegment) Read(p []byte) (int, error) {
+	if s.header > 0 {
+		n, err := s.Reader.Read(p[:min(len(p), s.header)])
+		s.header -= n
+		return n, err
+	}
+	time.Sleep(time.Millisecond)
+	return s.Reader.Read(p[:min(len(p), 32)])
+}
+
+func (s *slowSegment) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *slowSegment) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// slowDisk returns the segments of a 400 pixels disk split in 4, the pixels of every segment take over 2s to read
+func slowDisk(t *testing.T) []*slowSegment {
+	var segments []*slowSegment
+	for s := 1; s <= 4; s++ {
+		data := encodeTest(t, testFile(binary.LittleEndian, 3, 400, 100, uint8(s), 4), testPixels(400*100))
+		segments = append(segments, &slowSegment{Reader: bytes.NewReader(data), header: len(data) - 2*400*100})
+	}
+	return segments
+}
+
+// decodeSlowDisk decodes segments, failing the test if it takes longer than half a full read, segments are read
+// 20000 bytes at a time so a canceled segment still finishes reading a quarter of its pixels
+func decodeSlowDisk(t *testing.T, ctx context.Context, segments []*slowSegment) error {
+	sections := make([]io.ReadCloser, len(segments))
+	for i, s := range segments {
+		sections[i] = s
+	}
+	start := time.Now()
+	_, err := Decode(ctx, sections, Options{})
+	if d := time.Since(start); d > 1500*time.Millisecond {
+		t.Errorf("expected the decode to stop promptly but it took %s", d)
+	}
+	for i, s := range segments {
+		if !s.isClosed() {
+			t.Errorf("expected segment %d to be closed", i+1)
+		}
+	}
+	return err
+}
+
+func TestDecodeCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	err := decodeSlowDisk(t, ctx, slowDisk(t))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the decode to be canceled but got %v", err)
+	}
+}
+
+func TestDecodeSiblingError(t *testing.T) {
+	segments := slowDisk(t)
+	// Segment 2 is cut after its header and a few lines, it fails while the others are still decoding
+	data := encodeTest(t, testFile(binary.LittleEndian, 3, 400, 100, 2, 4), testPixels(400*100))
+	segments[1].Reader = bytes.NewReader(data[:len(data)-2*400*95])
+
+	err := decodeSlowDisk(t, context.Background(), segments)
+	var segErr *SegmentError
+	if !errors.As(err, &segErr) || segErr.Segment != 2 {
+		t.Errorf("expected a segment 2 error but got %v", err)
+	}
+}
+
+// memorySegment returns a little endian segment of width x height counts, positioned at the image data
+func memorySegment(width, height int, counts []uint16) *HMFile {
+	data := make([]byte, 2*len(counts))
+	for i, c := range counts {
+		binary.LittleEndian.PutUint16(data[2*i:], c)
+	}
+	h := &HMFile{ImageData: bytes.NewReader(data), bufferSize: 64}
+	h.BasicInfo.ByteOrder = binary.LittleEndian
+	h.DataInfo.NumberOfColumns = uint16(width)
+	h.DataInfo.NumberOfLines = uint16(height)
+	h.CalibrationInfo.BandNumber = 3
+	h.CalibrationInfo.ValidNumberOfBitsPerPixel = 11
+	h.CalibrationInfo.CountValueOfErrorPixels = 65535
+	h.CalibrationInfo.CountValueOfPixelsOutsideScanArea = 65534
+	h.SegmentInfo.SegmentTotalNumber = 1
+	h.SegmentInfo.SegmentSequenceNumber = 1
+	return h
+}
+
+func TestDecodeSectionFilters(t *testing.T) {
+	const space, bad = 65534, 65535
+	// 6x4 segment decoded at a third of the resolution into 2x1 pixels
+	counts := []uint16{
+		10, 20, 30, space, space, space,
+		40, 50, 60, space, space, space,
+		70, 80, 90, space, space, 100,
+		bad, bad, bad, space, bad, space,
+	}
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []uint16
+	}{
+		{name: "skip", filter: FilterSkip, want: []uint16{10, space}},
+		// Area averages ignore invalid counts, 100 is the only valid count of the second area
+		{name: "box", filter: FilterBox, want: []uint16{50, 100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := memorySegment(6, 4, counts)
+			d := calculateScaling(h, Options{Downsample: 3, Filter: tt.filter})
+			if d.scaledWidth != 2 || d.scaledHeight != 1 {
+				t.Fatalf("expected a 2x1 section but got %dx%d", d.scaledWidth, d.scaledHeight)
+			}
+			got := make([]uint16, d.scaledWidth*d.scaledHeight)
+			err := decodeSection(context.Background(), h, d, func(_ *HMFile, x, y int, count uint16) {
+				got[y*d.scaledWidth+x] = count
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected %v but got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCheckSegment(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(h *HMFile)
+		field  string
+	}{
+		{name: "same observation", modify: func(h *HMFile) { h.SegmentInfo.SegmentSequenceNumber = 2 }},
+		{name: "satellite", modify: func(h *HMFile) { copy(h.BasicInfo.Satellite[:], "Himawari-8") }, field: "satellite"},
+		{name: "band", modify: func(h *HMFile) { h.CalibrationInfo.BandNumber = 2 }, field: "band"},
+		{name: "timeline", modify: func(h *HMFile) { h.BasicInfo.ObservationTimeline = 40 }, field: "timeline"},
+		{name: "resolution", modify: func(h *HMFile) { h.DataInfo.NumberOfColumns = 8 }, field: "columns"},
+		{name: "sequence number", modify: func(h *HMFile) { h.SegmentInfo.SegmentSequenceNumber = 3 }, field: "segment"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first := memorySegment(4, 2, make([]uint16, 8))
+			first.SegmentInfo.SegmentTotalNumber = 2
+			copy(first.BasicInfo.Satellite[:], "Himawari-9")
+			first.BasicInfo.ObservationTimeline = 30
+			h := memorySegment(4, 2, make([]uint16, 8))
+			h.BasicInfo = first.BasicInfo
+			h.SegmentInfo.SegmentTotalNumber = 2
+			tt.modify(h)
+
+			err := checkSegment(first, h)
+			var mismatch *MismatchError
+			if tt.field == "" && err != nil {
+				t.Errorf("expected no error but got %v", err)
+			} else if tt.field != "" && (!errors.As(err, &mismatch) || mismatch.Field != tt.field) {
+				t.Errorf("expected a %s mismatch but got %v", tt.field, err)
+			}
+		})
+	}
+}
+
+func TestFillMissing(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 10))
+	noData := color.RGBA{R: 255, A: 255}
+	fillMissing(img, 5, []int{2, 5}, noData)
+	for y := 0; y < 10; y++ {
+		want := color.RGBA{}
+		if y == 2 || y == 3 || y >= 8 {
+			want = noData
+		}
+		if got := img.RGBAAt(1, y); got != want {
+			t.Errorf("expected %v at line %d but got %v", want, y, got)
+		}
+	}
+}
+
+func TestDecodeNoSegments(t *testing.T) {
+	_, err := Decode(context.Background(), []io.ReadCloser{nil, nil}, Options{AllowMissing: true})
+	if err == nil {
+		t.Errorf("expected an error without segments")
+	}
+}
+
+// testDisk returns the encoded segments of a full disk of band of columns x columns pixels, the projection is scaled
+// to the size of the disk, modify changes the headers before encoding
+func testDisk(t testing.TB, o binary.ByteOrder, band uint16, columns uint16, total uint8, modify ...func(f *HMFile)) []io.ReadCloser {
+	lines := columns / uint16(total)
+	segments := make([]io.ReadCloser, total)
+	for s := range segments {
+		f := testFile(o, band, columns, lines, uint8(s+1), total)
+		f.ProjectionInfo.CFAC = uint32(float64(f.ProjectionInfo.CFAC) * float64(columns) / 11000)
+		f.ProjectionInfo.LFAC = f.ProjectionInfo.CFAC
+		f.ProjectionInfo.COFF = float32(columns)/2 + 0.5
+		f.ProjectionInfo.LOFF = f.ProjectionInfo.COFF
+		for _, m := range modify {
+			m(f)
+		}
+		segments[s] = testSegment(t, f, testPixels(int(columns)*int(lines)))
+	}
+	return segments
+}
+
+func TestDecodeSegments(t *testing.T) {
+	noData := color.RGBA{R: 48, G: 48, B: 48, A: 255}
+	mismatched := testFile(binary.LittleEndian, 1, 40, 10, 2, 4)
+	tests := []struct {
+		name    string
+		modify  func(segments []io.ReadCloser)
+		opts    Options
+		missing []int
+		wantErr func(err error) bool
+	}{
+		{name: "complete", modify: func([]io.ReadCloser) {}},
+		{
+			name: "unordered",
+			modify: func(segments []io.ReadCloser) {
+				segments[0], segments[3] = segments[3], segments[0]
+			},
+		},
+		{
+			name:    "missing",
+			modify:  func(segments []io.ReadCloser) { segments[1] = nil },
+			wantErr: func(err error) bool { return errors.Is(err, ErrMissingSegment) },
+		},
+		{
+			name: "duplicate",
+			modify: func(segments []io.ReadCloser) {
+				segments[1] = testSegment(t, testFile(binary.LittleEndian, 2, 40, 10, 3, 4), testPixels(400))
+			},
+			wantErr: func(err error) bool { return errors.Is(err, ErrDuplicateSegment) },
+		},
+		{
+			name:   "mismatched band",
+			modify: func(segments []io.ReadCloser) { segments[1] = testSegment(t, mismatched, testPixels(400)) },
+			opts:   Options{AllowMissing: true},
+			wantErr: func(err error) bool {
+				var e *MismatchError
+				return errors.As(err, &e) && e.Field == "band"
+			},
+		},
+		{
+			name: "allow missing",
+			modify: func(segments []io.ReadCloser) {
+				segments[0] = nil
+				// Truncated download
+				data, _ := io.ReadAll(segments[2])
+				segments[2] = io.NopCloser(bytes.NewReader(data[:len(data)-100]))
+			},
+			opts:    Options{AllowMissing: true, NoData: noData},
+			missing: []int{1, 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			segments := testDisk(t, binary.LittleEndian, 2, 40, 4)
+			tt.modify(segments)
+			res, err := Decode(context.Background(), segments, tt.opts)
+			if tt.wantErr != nil {
+				if !tt.wantErr(err) {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b := res.Image.Bounds(); b.Dx() != 40 || b.Dy() != 40 {
+				t.Fatalf("expected a 40x40 image but got %s", b)
+			}
+			if !slices.Equal(res.Missing, tt.missing) {
+				t.Errorf("expected missing segments %v but got %v", tt.missing, res.Missing)
+			}
+			for y := 0; y < 40; y++ {
+				missing := slices.Contains(tt.missing, y/10+1)
+				// Every segment has the same counts
+				want := greyPixel(res.Header, uint16(y%10*40+39))
+				if missing {
+					want = noData
+				}
+				if got := res.Image.RGBAAt(39, y); got != want {
+					t.Fatalf("expected %v at line %d but got %v", want, y, got)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeByteOrders(t *testing.T) {
+	for _, opts := range []Options{{Downsample: 1}, {Downsample: 3, Filter: FilterSkip}, {Downsample: 3, Filter: FilterBox}} {
+		var images []*image.RGBA
+		for _, o := range byteOrders {
+			res, err := Decode(context.Background(), testDisk(t, o, 13, 60, 6), opts)
+			if err != nil {
+				t.Fatalf("failed to decode a %s disk: %s", o, err)
+			}
+			if res.Header.BasicInfo.ByteOrder != o {
+				t.Errorf("expected a %s header but got %s", o, res.Header.BasicInfo.ByteOrder)
+			}
+			images = append(images, res.Image)
+		}
+		if !slices.Equal(images[0].Pix, images[1].Pix) {
+			t.Errorf("little and big endian disks decode differently with %+v", opts)
+		}
+	}
+}
+
+func TestDecodeFullDisk(t *testing.T) {
+	disk := testDisk(t, binary.LittleEndian, 2, 40, FullDiskSegments)
+	var mu sync.Mutex
+	var opened []*closeRecorder
+	open := func(_ context.Context, segment int) (io.ReadCloser, error) {
+		if segment == 3 {
+			return nil, errors.New("503 Service Unavailable")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		s := &closeRecorder{Reader: disk[segment-1]}
+		opened = append(opened, s)
+		return s, nil
+	}
+	res, err := DecodeFullDisk(context.Background(), open, Options{AllowMissing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(res.Missing, []int{3}) {
+		t.Errorf("expected segment 3 to be missing but got %v", res.Missing)
+	}
+	if len(opened) != FullDiskSegments-1 {
+		t.Errorf("expected %d segments to be opened but got %d", FullDiskSegments-1, len(opened))
+	}
+	for i, s := range opened {
+		if !s.closed {
+			t.Errorf("expected opened segment %d to be closed", i+1)
+		}
+	}
+
+	// Without AllowMissing the failed open is the error
+	var segErr *SegmentError
+	disk = testDisk(t, binary.LittleEndian, 2, 40, FullDiskSegments)
+	if _, err = DecodeFullDisk(context.Background(), open, Options{}); !errors.As(err, &segErr) || segErr.Segment != 3 {
+		t.Errorf("expected a segment 3 error but got %v", err)
+	}
+}
+
+func TestDecodeFullDiskCancelOpen(t *testing.T) {
+	disk := testDisk(t, binary.LittleEndian, 2, 40, FullDiskSegments)
+	canceled := make(chan struct{})
+	open := func(ctx context.Context, segment int) (io.ReadCloser, error) {
+		switch segment {
+		case 2:
+			// Truncated download
+			data, _ := io.ReadAll(disk[1])
+			return io.NopCloser(bytes.NewReader(data[:len(data)-100])), nil
+		case 5:
+			// Stalled download, only the context stops it
+			<-ctx.Done()
+			close(canceled)
+			return nil, ctx.Err()
+		}
+		return disk[segment-1], nil
+	}
+	done := make(chan error)
+	go func() {
+		_, err := DecodeFullDisk(context.Background(), open, Options{})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		var segErr *SegmentError
+		if !errors.As(err, &segErr) || segErr.Segment != 2 {
+			t.Errorf("expected a segment 2 error but got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the stalled open to be canceled")
+	}
+	select {
+	case <-canceled:
+	default:
+		t.Errorf("expected the context of the stalled open to be canceled")
+	}
+}
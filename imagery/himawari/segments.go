@@ -0,0 +1,45 @@
+package himawari
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// FullDiskSegments is the amount of segments a full disk (FLDK) observation is split into
+	FullDiskSegments = 10
+	// ObservationInterval is how often a full disk observation starts
+	ObservationInterval = 10 * time.Minute
+)
+
+// Resolution returns the HSD resolution tag of a band, bands 1, 2 and 4 are 1km, band 3 is 0.5km and the rest 2km
+func Resolution(band int) string {
+	switch band {
+	case 3:
+		return "R05"
+	case 1, 2, 4:
+		return "R10"
+	default:
+		return "R20"
+	}
+}
+
+// DiskSize returns the width and height in pixels of the full disk of a band
+func DiskSize(band int) int {
+	switch Resolution(band) {
+	case "R05":
+		return 22000
+	case "R10":
+		return 11000
+	default:
+		return 5500
+	}
+}
+
+// SegmentName returns the file name of a full disk segment, e.g. HS_H09_20231130_0030_B03_FLDK_R05_S0110.DAT
+// satellite is the short satellite name (H08 or H09) and segment starts at 1
+func SegmentName(satellite string, t time.Time, band int, segment int) string {
+	t = t.UTC()
+	return fmt.Sprintf("HS_%s_%s_%s_B%02d_FLDK_%s_S%02d%02d.DAT",
+		satellite, t.Format("20060102"), t.Format("1504"), band, Resolution(band), segment, FullDiskSegments)
+}
@@ -0,0 +1,28 @@
+package himawari
+
+import (
+	"math"
+)
+
+// SubSolarPoint returns the latitude and longitude in degrees where the Sun is at the zenith at the navigation time
+// SunPosition is inertial (J2000), so its right ascension is turned into a longitude with the sidereal time
+func (n NavigationInformationBlock) SubSolarPoint() (lat, lon float64) {
+	s := n.SunPosition
+	lat = radToDeg(math.Atan2(s.Z, math.Hypot(s.X, s.Y)))
+	rightAscension := radToDeg(math.Atan2(s.Y, s.X))
+	return lat, normalizeLon(rightAscension - siderealTime(n.NavigationTime))
+}
+
+// siderealTime returns the Greenwich mean sidereal time in degrees of a modified julian date
+func siderealTime(mjd float64) float64 {
+	// Days since J2000.0, which is MJD 51544.5
+	d := mjd - 51544.5
+	return math.Mod(280.46061837+360.98564736629*d, 360)
+}
+
+// SolarZenith returns the angle in degrees between the zenith of a position and the Sun at the sub solar point
+func SolarZenith(lat, lon, sunLat, sunLon float64) float64 {
+	lat, sunLat = degToRad(lat), degToRad(sunLat)
+	cos := math.Sin(lat)*math.Sin(sunLat) + math.Cos(lat)*math.Cos(sunLat)*math.Cos(degToRad(lon-sunLon))
+	return radToDeg(math.Acos(math.Max(-1, math.Min(cos, 1))))
+}
@@ -0,0 +1,39 @@
+package himawari
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSubSolarPoint(t *testing.T) {
+	// Navigation of the 2023-10-31 13:40 test segment, the Sun is over the Atlantic
+	n := NavigationInformationBlock{
+		NavigationTime: 60248.56964875857,
+		SunPosition:    Position{X: -117768009.68104868, Y: -83032296.6181277, Z: -35994351.16214465},
+	}
+	lat, lon := n.SubSolarPoint()
+	// Declination is about -14.2° and the solar noon is 16.4 minutes ahead of the mean noon at the end of October
+	wantLat := -14.2
+	wantLon := (12 - 16.4/60 - (13 + 40.0/60)) * 15
+	if math.Abs(lat-wantLat) > 0.5 || math.Abs(lon-wantLon) > 0.5 {
+		t.Errorf("expected the sub solar point at %f, %f but got %f, %f", wantLat, wantLon, lat, lon)
+	}
+}
+
+func TestSolarZenith(t *testing.T) {
+	tests := []struct {
+		lat, lon, sunLat, sunLon float64
+		want                     float64
+	}{
+		{lat: 10, lon: 20, sunLat: 10, sunLon: 20, want: 0},
+		{lat: 0, lon: 140.7, sunLat: 0, sunLon: 50.7, want: 90},
+		{lat: 0, lon: 140.7, sunLat: 0, sunLon: -39.3, want: 180},
+		{lat: 90, lon: 0, sunLat: -23.44, sunLon: 100, want: 113.44},
+	}
+	for _, tt := range tests {
+		got := SolarZenith(tt.lat, tt.lon, tt.sunLat, tt.sunLon)
+		if math.Abs(got-tt.want) > 1e-6 {
+			t.Errorf("expected zenith %f at %f, %f but got %f", tt.want, tt.lat, tt.lon, got)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package imagery
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"context"
+	"image/jpeg"
+	"io"
+	"matbm.net/geonow/imagery/himawari"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHimawariSegmentURL(t *testing.T) {
+	src := HimawariSource{BaseURL: "http://localhost", Band: 3}
+	obs := time.Date(2023, 11, 30, 0, 30, 0, 0, time.UTC)
+
+	got := src.segmentURL(obs, src.Band, 1)
+	expected := "http://localhost/AHI-L1b-FLDK/2023/11/30/0030/HS_H09_20231130_0030_B03_FLDK_R05_S0110.DAT.bz2"
+	if got != expected {
+		t.Errorf("expected %s but got %s", expected, got)
+	}
+}
+
+func TestHimawariLatestObservation(t *testing.T) {
+	obs := HimawariSource{}.observationTime()
+	if obs.Minute()%10 != 0 || obs.Second() != 0 {
+		t.Errorf("expected observation to start at a 10 minute slot, got %s", obs)
+	}
+	if time.Since(obs) < himawariDelay {
+		t.Errorf("expected observation to be at least %s old, got %s", himawariDelay, obs)
+	}
+}
+
+func TestHimawariDownloadMissingSegment(t *testing.T) {
+	var mu sync.Mutex
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.Path)
+		mu.Unlock()
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	obs := time.Date(2023, 11, 30, 0, 30, 0, 0, time.UTC)
+	_, err := HimawariSource{MaxWidth: 256, BaseURL: server.URL, Band: 3, Time: obs}.DownloadImage()
+	if err == nil {
+		t.Errorf("expected an error when segments are missing")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := "/AHI-L1b-FLDK/2023/11/30/0030/HS_H09_20231130_0030_B03_FLDK_R05_S0110.DAT.bz2"
+	if len(requested) == 0 || requested[0] != expected {
+		t.Errorf("expected first request to be %s but got %v", expected, requested)
+	}
+}
+
+// himawariTestColumns is the size of the full disk served by himawariTestServer, 10 lines per segment
+const himawariTestColumns = 100
+
+// himawariTestSegment returns the compressed segment of band 3 observed at obs from testdata, every count is its
+// column. The files are the segments built here compressed with bzip2 -9, which the standard library can't write
+func himawariTestSegment(t *testing.T, obs time.Time, segment int) []byte {
+	lines := himawariTestColumns / himawari.FullDiskSegments
+	f := himawari.NewSegment(3, himawariTestColumns, lines, segment, himawari.FullDiskSegments, obs)
+	pixels := make([]uint16, himawariTestColumns*lines)
+	for i := range pixels {
+		pixels[i] = uint16(i % himawariTestColumns * 20)
+	}
+	raw := &bytes.Buffer{}
+	if err := himawari.Encode(raw, f, pixels); err != nil {
+		t.Fatal(err)
+	}
+
+	name := filepath.Join("testdata", "himawari", himawari.SegmentName(himawariSatellite, obs, 3, segment)+".bz2")
+	compressed, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decompressed, err := io.ReadAll(bzip2.NewReader(bytes.NewReader(compressed)))
+	if err != nil {
+		t.Fatalf("failed to decompress %s: %s", name, err)
+	}
+	if !bytes.Equal(decompressed, raw.Bytes()) {
+		t.Fatalf("%s doesn't hold segment %d", name, segment)
+	}
+	return compressed
+}
+
+// himawariTestServer serves the segments of band 3 observed at obs, the failing segments answer 503
+func himawariTestServer(t *testing.T, obs time.Time, failing ...int) *httptest.Server {
+	segments := map[string][]byte{}
+	for s := 1; s <= himawari.FullDiskSegments; s++ {
+		if slices.Contains(failing, s) {
+			continue
+		}
+		name := himawari.SegmentName(himawariSatellite, obs, 3, s) + ".bz2"
+		segments[name] = himawariTestSegment(t, obs, s)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		segment, ok := segments[name]
+		if !ok {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write(segment)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHimawariDownloadImage(t *testing.T) {
+	obs := time.Date(2023, 11, 30, 0, 30, 0, 0, time.UTC)
+	server := himawariTestServer(t, obs)
+	// The 22000 pixels wide band 3 isn't downsampled, the test disk is only 100 pixels
+	src := HimawariSource{MaxWidth: himawari.DiskSize(3), BaseURL: server.URL, Band: 3, Time: obs}
+
+	r, start, err := src.DownloadTimedImage(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != himawariTestColumns || b.Dy() != himawariTestColumns {
+		t.Errorf("expected a %dx%d image but got %s", himawariTestColumns, himawariTestColumns, b)
+	}
+	// Counts grow with the column, the jpeg is lossy so only compare both sides
+	left, _, _, _ := img.At(5, 50).RGBA()
+	right, _, _, _ := img.At(95, 50).RGBA()
+	if left >= right {
+		t.Errorf("expected the left side to be darker than the right one but got %d and %d", left, right)
+	}
+	if d := start.Sub(obs); d < -time.Millisecond || d > time.Millisecond {
+		t.Errorf("expected the observation to start at %s but got %s", obs, start)
+	}
+}
+
+func TestHimawariDownloadFailingSegment(t *testing.T) {
+	obs := time.Date(2023, 11, 30, 0, 30, 0, 0, time.UTC)
+	server := himawariTestServer(t, obs, 3)
+	src := HimawariSource{MaxWidth: himawari.DiskSize(3), BaseURL: server.URL, Band: 3, Time: obs}
+
+	res, err := src.decode(context.Background(), obs)
+	if err != nil {
+		t.Fatalf("expected a failing segment not to be fatal but got %s", err)
+	}
+	if !slices.Equal(res.Missing, []int{3}) {
+		t.Errorf("expected segment 3 to be missing but got %v", res.Missing)
+	}
+	// Segment 3 is lines 20 to 29, filled with the no data colour
+	if got := res.Image.RGBAAt(50, 25); got != himawariNoData {
+		t.Errorf("expected the missing segment to be %v but got %v", himawariNoData, got)
+	}
+
+	// The image is still rendered
+	if _, err = src.DownloadImage(); err != nil {
+		t.Errorf("expected an image despite the failing segment but got %s", err)
+	}
+}
+
+func TestHimawariDownsample(t *testing.T) {
+	tests := []struct {
+		maxWidth int
+		band     int
+		want     int
+	}{
+		{maxWidth: 10000, band: 1, want: 2},
+		{maxWidth: 10000, band: 3, want: 3},
+		{maxWidth: 11000, band: 1, want: 1},
+		{maxWidth: 5500, band: 13, want: 1},
+		{maxWidth: 1920, band: 3, want: 12},
+		{maxWidth: 30000, band: 3, want: 1},
+		{maxWidth: 0, band: 13, want: 5500},
+	}
+	for _, tt := range tests {
+		got := HimawariSource{MaxWidth: tt.maxWidth}.downsample(tt.band)
+		if got != tt.want {
+			t.Errorf("expected downsample %d of band %d at %d pixels but got %d", tt.want, tt.band, tt.maxWidth, got)
+		}
+		if width := himawari.DiskSize(tt.band) / got; tt.maxWidth > 0 && width > tt.maxWidth {
+			t.Errorf("expected band %d to be at most %d pixels but got %d", tt.band, tt.maxWidth, width)
+		}
+	}
+}
@@ -2,10 +2,64 @@ package imagery
 
 import (
 	"bufio"
-	"fmt"
+	"context"
+	"errors"
 	"io"
+	"matbm.net/geonow/imagery/himawari"
+	"slices"
+	"time"
 )
 
+var (
+	// ErrInvalidSource is returned by GetSource for sources that don't exist
+	ErrInvalidSource = errors.New("invalid source")
+	// ErrInvalidPalette is returned by GetSource for palettes that don't exist or sources without infrared renders
+	ErrInvalidPalette = errors.New("invalid palette")
+)
+
+// sourceFunc returns a source configured by p, palette is nil for the default one
+type sourceFunc func(p *Parameters, palette *himawari.Palette) (ImageSource, error)
+
+// sources are the sources GetSource knows by name, only himawari-ir and the night side of himawari-daynight are
+// coloured by a palette
+var sources = map[string]sourceFunc{
+	"goes": withoutPalette(func(p *Parameters) ImageSource {
+		return GoesSource{p.MaxWidth}
+	}),
+	"himawari": withoutPalette(func(p *Parameters) ImageSource {
+		return HimawariSource{MaxWidth: p.MaxWidth, BaseURL: p.HimawariBaseURL, Band: himawariBand}
+	}),
+	"himawari-ir": func(p *Parameters, palette *himawari.Palette) (ImageSource, error) {
+		return HimawariSource{MaxWidth: p.MaxWidth, BaseURL: p.HimawariBaseURL, Band: himawariInfraredBand, Palette: palette}, nil
+	},
+	"himawari-truecolor": withoutPalette(func(p *Parameters) ImageSource {
+		return HimawariSource{MaxWidth: p.MaxWidth, BaseURL: p.HimawariBaseURL, TrueColor: true}
+	}),
+	"himawari-daynight": func(p *Parameters, palette *himawari.Palette) (ImageSource, error) {
+		return HimawariSource{MaxWidth: p.MaxWidth, BaseURL: p.HimawariBaseURL, DayNight: true, Palette: palette}, nil
+	},
+}
+
+// withoutPalette returns a sourceFunc of a source that isn't coloured by a palette, asking for one is ErrInvalidPalette
+func withoutPalette(source func(p *Parameters) ImageSource) sourceFunc {
+	return func(p *Parameters, palette *himawari.Palette) (ImageSource, error) {
+		if palette != nil {
+			return nil, ErrInvalidPalette
+		}
+		return source(p), nil
+	}
+}
+
+// SourceNames returns the names of the sources GetSource knows, sorted
+func SourceNames() []string {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
 type ImageSource interface {
 	// DownloadImage Downloads an image to a reader
 	DownloadImage() (*bufio.Reader, error)
@@ -16,15 +70,35 @@ type ImageSource interface {
 	SourceURL() string
 }
 
+// TimedSource is implemented by sources that know when their images were observed
+type TimedSource interface {
+	// DownloadTimedImage Downloads an image like DownloadImage alongside the time its observation started, until
+	// ctx is done
+	DownloadTimedImage(ctx context.Context) (*bufio.Reader, time.Time, error)
+}
+
 type Parameters struct {
 	// MaxWidth defines what is the max width of the images
 	MaxWidth int
+	// HimawariBaseURL is where himawari segments are downloaded from
+	HimawariBaseURL string
+	// Palette is the name of the palette of infrared himawari renders, empty is the default one, only himawari-ir
+	// and the night side of himawari-daynight are coloured by it
+	Palette string
 }
 
 func GetSource(src string, p *Parameters) (ImageSource, error) {
-	if src == "goes" {
-		return GoesSource{p.MaxWidth}, nil
+	source, ok := sources[src]
+	if !ok {
+		return nil, ErrInvalidSource
 	}
-
-	return nil, fmt.Errorf("invalid source")
+	var palette *himawari.Palette
+	if p.Palette != "" {
+		pal, ok := himawari.Palettes[p.Palette]
+		if !ok {
+			return nil, ErrInvalidPalette
+		}
+		palette = &pal
+	}
+	return source(p, palette)
 }
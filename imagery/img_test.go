@@ -0,0 +1,28 @@
+package imagery
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGetSource(t *testing.T) {
+	palettes := map[string]bool{"himawari-ir": true, "himawari-daynight": true}
+	for _, name := range SourceNames() {
+		if _, err := GetSource(name, &Parameters{MaxWidth: 100}); err != nil {
+			t.Errorf("expected source %q but got %s", name, err)
+		}
+		_, err := GetSource(name, &Parameters{MaxWidth: 100, Palette: "bd"})
+		if palettes[name] && err != nil {
+			t.Errorf("expected source %q with a palette but got %s", name, err)
+		}
+		if !palettes[name] && !errors.Is(err, ErrInvalidPalette) {
+			t.Errorf("expected an invalid palette for source %q but got %v", name, err)
+		}
+	}
+	if _, err := GetSource("himawari-ir", &Parameters{Palette: "unknown"}); !errors.Is(err, ErrInvalidPalette) {
+		t.Errorf("expected an invalid palette but got %v", err)
+	}
+	if _, err := GetSource("unknown", &Parameters{Palette: "unknown"}); !errors.Is(err, ErrInvalidSource) {
+		t.Errorf("expected an invalid source but got %v", err)
+	}
+}